@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// uploadArchive uploads every regular file in the .zip, .tar.gz or .tgz
+// archive at archivePath as an object below remotePath, reading the members
+// into memory instead of extracting them to disk.
+func (u *uploader) uploadArchive(ctx context.Context, archivePath, remotePath string) error {
+	lower := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return u.uploadZip(ctx, archivePath, remotePath)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return u.uploadTarGz(ctx, archivePath, remotePath)
+	default:
+		return fmt.Errorf("%s is not a .zip or .tar.gz archive", archivePath)
+	}
+}
+
+func (u *uploader) uploadZip(ctx context.Context, archivePath, remotePath string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			u.handleError(archivePath+":"+f.Name, "", err)
+			continue
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+
+		u.uploadMember(ctx, archivePath, f.Name, remotePath, data, err)
+	}
+	return nil
+}
+
+func (u *uploader) uploadTarGz(ctx context.Context, archivePath, remotePath string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		u.uploadMember(ctx, archivePath, hdr.Name, remotePath, data, err)
+	}
+}
+
+// uploadMember uploads one archive member read with readErr, reporting
+// failures like those of regular files.
+func (u *uploader) uploadMember(ctx context.Context, archivePath, name, remotePath string, data []byte, readErr error) {
+	member := archivePath + ":" + name
+	if readErr != nil {
+		u.handleError(member, "", readErr)
+		return
+	}
+
+	rel := path.Clean(strings.TrimLeft(name, "/"))
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		u.handleError(member, "", fmt.Errorf("member %q escapes the remote path", name))
+		return
+	}
+
+	key := u.finalKey(u.rewriteKey(strings.TrimPrefix(path.Join(remotePath, rel), "/")))
+	if err := u.checkKey(member, key); err != nil {
+		u.handleError(member, key, err)
+		return
+	}
+	if err := u.uploadBytes(ctx, name, key, data); err != nil && ctx.Err() == nil {
+		u.handleError(member, key, err)
+	}
+}
+
+// uploadBytes uploads data as key. It is the in-memory counterpart of
+// uploadFile for archive members, name is used to pick the content type and
+// headers. Only --force decides about existing objects.
+func (u *uploader) uploadBytes(ctx context.Context, name, key string, data []byte) error {
+	size := int64(len(data))
+
+	skip, reason := false, "force"
+	if !u.force {
+		exists, err := u.exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		skip, reason = exists, "new"
+		if skip {
+			reason = "exists"
+		}
+	}
+
+	if u.dryRun {
+		return u.planFile(ctx, name, key, skip, reason, size)
+	}
+
+	if skip {
+		u.logf("\"%s\" is %s will be skipped", key, reason)
+		if u.events != nil {
+			u.events.emit(progressEvent{Event: "skip", Path: name, Key: key, Reason: reason})
+		}
+		u.skipped++
+		return nil
+	}
+
+	mimeType := u.contentType(name, func() []byte { return data })
+
+	u.logf("Uploading [% 4d] %s as %s", u.uploaded, key, mimeType)
+
+	var progress func(int64, int64)
+	switch {
+	case u.events != nil:
+		u.events.emit(progressEvent{Event: "start", Path: name, Key: key, Total: size})
+		progress = u.events.reader(name, key)
+	case !u.quiet:
+		progress = newProgressPrinter(key).update
+	}
+
+	headers := u.headersFor(name, key)
+
+	var contentMD5 *string
+	if u.contentMD5 {
+		sum := md5.Sum(data)
+		contentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	var sha256Sum string
+	if u.checksumMetadata || u.contentSHA256 {
+		sum := sha256.Sum256(data)
+		sha256Sum = hex.EncodeToString(sum[:])
+	}
+	if u.checksumMetadata {
+		headers = headers.merge(objectHeaders{Metadata: map[string]string{sha256MetadataKey: sha256Sum}})
+	}
+
+	options := u.putOptions
+	if u.contentSHA256 {
+		options = append(options[:len(options):len(options)], withPayloadSHA256(sha256Sum))
+	}
+
+	err := withRetry(ctx, func() error {
+		input := &s3.PutObjectInput{
+			Bucket:        aws.String(bucketName),
+			Key:           aws.String(key),
+			RequestPayer:  requestPayer(),
+			Body:          NewProgressReader(u.limit(ctx, bytes.NewReader(data)), size, progress),
+			ContentType:   aws.String(mimeType),
+			ContentLength: size,
+			ContentMD5:    contentMD5,
+		}
+		headers.apply(input)
+
+		_, err := u.client.PutObject(ctx, input, options...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := u.tagObject(ctx, key); err != nil {
+		return err
+	}
+
+	u.uploaded++
+	u.bytes += size
+	if u.events != nil {
+		u.events.emit(progressEvent{Event: "done", Path: name, Key: key, Bytes: size, Total: size})
+	}
+	return nil
+}
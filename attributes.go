@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/cobra"
+)
+
+// objectAttributes is the JSON printed by get-object-attributes, attributes
+// that were not asked for are left out.
+type objectAttributes struct {
+	Key          string                          `json:"key"`
+	VersionID    string                          `json:"versionId,omitempty"`
+	LastModified *time.Time                      `json:"lastModified,omitempty"`
+	ETag         string                          `json:"etag,omitempty"`
+	ObjectSize   *int64                          `json:"objectSize,omitempty"`
+	StorageClass types.StorageClass              `json:"storageClass,omitempty"`
+	Checksum     *types.Checksum                 `json:"checksum,omitempty"`
+	ObjectParts  *types.GetObjectAttributesParts `json:"objectParts,omitempty"`
+}
+
+// parseObjectAttributes checks the names given to --attributes.
+func parseObjectAttributes(names []string) ([]types.ObjectAttributes, error) {
+	known := types.ObjectAttributes("").Values()
+
+	attributes := make([]types.ObjectAttributes, 0, len(names))
+next:
+	for _, name := range names {
+		for _, attr := range known {
+			if strings.EqualFold(name, string(attr)) {
+				attributes = append(attributes, attr)
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("unknown attribute %q, expected one of %v", name, known)
+	}
+	return attributes, nil
+}
+
+func getObjectAttributesCmd() *cobra.Command {
+	attributes := &cobra.Command{
+		Use:   "get-object-attributes <key>",
+		Short: "print the attributes of an object as JSON without downloading it",
+		Long:  "",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			names, _ := cmd.Flags().GetStringSlice("attributes")
+
+			key := strings.TrimLeft(args[0], "/")
+
+			wanted, err := parseObjectAttributes(names)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var out *s3.GetObjectAttributesOutput
+			err = withRetry(ctx, func() (err error) {
+				out, err = client.GetObjectAttributes(ctx, &s3.GetObjectAttributesInput{
+					Bucket:           aws.String(bucketName),
+					Key:              aws.String(key),
+					ObjectAttributes: wanted,
+					RequestPayer:     requestPayer(),
+				})
+				return err
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			result := objectAttributes{
+				Key:          key,
+				VersionID:    aws.ToString(out.VersionId),
+				LastModified: out.LastModified,
+				ETag:         aws.ToString(out.ETag),
+				StorageClass: out.StorageClass,
+				Checksum:     out.Checksum,
+				ObjectParts:  out.ObjectParts,
+			}
+			for _, attr := range wanted {
+				if attr == types.ObjectAttributesObjectSize {
+					result.ObjectSize = aws.Int64(out.ObjectSize)
+				}
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(result); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+
+	attributes.Flags().StringSlice("attributes", []string{"ETag", "Checksum", "ObjectParts", "ObjectSize", "StorageClass"}, "Comma separated attributes to fetch: ETag, Checksum, ObjectParts, ObjectSize and StorageClass.")
+
+	return attributes
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/cobra"
+)
+
+func bucketCmd() *cobra.Command {
+	bucket := &cobra.Command{
+		Use:   "bucket",
+		Short: "manage settings of the bucket",
+		Long:  "",
+	}
+
+	bucket.AddCommand(bucketVersioningCmd())
+	bucket.AddCommand(bucketTagsCmd())
+	bucket.AddCommand(bucketObjectLockCmd())
+
+	return bucket
+}
+
+func bucketVersioningCmd() *cobra.Command {
+	versioning := &cobra.Command{
+		Use:   "versioning",
+		Short: "show, enable or suspend versioning of the bucket",
+	}
+
+	versioning.AddCommand(&cobra.Command{
+		Use:   "get",
+		Short: "show the versioning status of the bucket",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var out *s3.GetBucketVersioningOutput
+			err = withRetry(ctx, func() (err error) {
+				out, err = client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+					Bucket: aws.String(bucketName),
+				})
+				return err
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			// a bucket that never had versioning enabled has no status
+			status := string(out.Status)
+			if status == "" {
+				status = "Disabled"
+			}
+			fmt.Printf("Versioning of %s: %s\n", bucketName, status)
+		},
+	})
+
+	versioning.AddCommand(&cobra.Command{
+		Use:   "enable",
+		Short: "enable versioning of the bucket",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			setBucketVersioning(types.BucketVersioningStatusEnabled)
+		},
+	})
+
+	versioning.AddCommand(&cobra.Command{
+		Use:   "suspend",
+		Short: "suspend versioning of the bucket, existing versions are kept",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			log.Println("Warning: suspending versioning does not delete existing versions, they keep using storage until deleted")
+			setBucketVersioning(types.BucketVersioningStatusSuspended)
+		},
+	})
+
+	return versioning
+}
+
+func setBucketVersioning(status types.BucketVersioningStatus) {
+	ctx := context.Background()
+
+	client, err := newR2Client(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = withRetry(ctx, func() error {
+		_, err := client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+			Bucket:                  aws.String(bucketName),
+			VersioningConfiguration: &types.VersioningConfiguration{Status: status},
+		})
+		return err
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Versioning of %s is %s", bucketName, status)
+}
+
+func bucketObjectLockCmd() *cobra.Command {
+	lock := &cobra.Command{
+		Use:   "object-lock",
+		Short: "set the default retention of new objects in the bucket",
+		Long:  "Objects uploaded without a retention of their own inherit the default retention. Show it with lock-file describe-policy.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			mode, _ := cmd.Flags().GetString("object-lock-default-mode")
+			days, _ := cmd.Flags().GetInt32("object-lock-default-retention-days")
+
+			retention := types.ObjectLockRetentionMode(strings.ToUpper(mode))
+			if retention != types.ObjectLockRetentionModeGovernance && retention != types.ObjectLockRetentionModeCompliance {
+				log.Fatalf("unknown retention mode %q, expected GOVERNANCE or COMPLIANCE", mode)
+			}
+			if days <= 0 {
+				log.Fatalln("--object-lock-default-retention-days must be at least 1")
+			}
+
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			err = withRetry(ctx, func() error {
+				_, err := client.PutObjectLockConfiguration(ctx, &s3.PutObjectLockConfigurationInput{
+					Bucket: aws.String(bucketName),
+					ObjectLockConfiguration: &types.ObjectLockConfiguration{
+						ObjectLockEnabled: types.ObjectLockEnabledEnabled,
+						Rule: &types.ObjectLockRule{
+							DefaultRetention: &types.DefaultRetention{
+								Mode: retention,
+								Days: days,
+							},
+						},
+					},
+					RequestPayer: requestPayer(),
+				})
+				return err
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+			log.Printf("New objects in %s are locked in %s mode for %d days", bucketName, retention, days)
+		},
+	}
+
+	lock.Flags().String("object-lock-default-mode", "", "Default retention mode: GOVERNANCE or COMPLIANCE.")
+	lock.Flags().Int32("object-lock-default-retention-days", 0, "Number of days new objects are retained.")
+	lock.MarkFlagRequired("object-lock-default-mode")
+	lock.MarkFlagRequired("object-lock-default-retention-days")
+
+	return lock
+}
+
+// maxBucketTags is the number of tags a bucket can have.
+const maxBucketTags = 50
+
+func bucketTagsCmd() *cobra.Command {
+	tags := &cobra.Command{
+		Use:   "tags",
+		Short: "show, set or delete the tags of the bucket",
+	}
+
+	tags.AddCommand(&cobra.Command{
+		Use:   "get",
+		Short: "show the tags of the bucket",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var out *s3.GetBucketTaggingOutput
+			err = withRetry(ctx, func() (err error) {
+				out, err = client.GetBucketTagging(ctx, &s3.GetBucketTaggingInput{
+					Bucket: aws.String(bucketName),
+				})
+				return err
+			})
+			// a bucket without tags answers NoSuchTagSet
+			if err != nil && !strings.Contains(err.Error(), "NoSuchTagSet") {
+				log.Fatalln(err)
+			}
+
+			if err != nil || len(out.TagSet) == 0 {
+				fmt.Printf("%s has no tags\n", bucketName)
+				return
+			}
+			for _, tag := range out.TagSet {
+				fmt.Printf("%s=%s\n", aws.ToString(tag.Key), aws.ToString(tag.Value))
+			}
+		},
+	})
+
+	set := &cobra.Command{
+		Use:   "set",
+		Short: "replace the tags of the bucket",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			pairs, _ := cmd.Flags().GetStringArray("tag")
+
+			tagSet, err := parseBucketTags(pairs)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			err = withRetry(ctx, func() error {
+				_, err := client.PutBucketTagging(ctx, &s3.PutBucketTaggingInput{
+					Bucket:  aws.String(bucketName),
+					Tagging: &types.Tagging{TagSet: tagSet},
+				})
+				return err
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+			log.Printf("Set %d tags on %s", len(tagSet), bucketName)
+		},
+	}
+	set.Flags().StringArray("tag", nil, "Tag as key=value, can be repeated. Replaces all existing tags.")
+	set.MarkFlagRequired("tag")
+	tags.AddCommand(set)
+
+	tags.AddCommand(&cobra.Command{
+		Use:   "delete",
+		Short: "delete all tags of the bucket",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if confirmBeforeDelete && !assumeYes && !confirm(fmt.Sprintf("Delete all tags of %s?", bucketName)) {
+				log.Println("Keeping the tags")
+				return
+			}
+
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			err = withRetry(ctx, func() error {
+				_, err := client.DeleteBucketTagging(ctx, &s3.DeleteBucketTaggingInput{
+					Bucket: aws.String(bucketName),
+				})
+				return err
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+			log.Printf("Deleted the tags of %s", bucketName)
+		},
+	})
+
+	return tags
+}
+
+// parseBucketTags turns key=value pairs into a tag set.
+func parseBucketTags(pairs []string) ([]types.Tag, error) {
+	if len(pairs) > maxBucketTags {
+		return nil, fmt.Errorf("%d tags given, a bucket can have at most %d", len(pairs), maxBucketTags)
+	}
+
+	tagSet := make([]types.Tag, 0, len(pairs))
+	seen := map[string]bool{}
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		if k == "" || utf8.RuneCountInString(k) > maxTagKeyLength {
+			return nil, fmt.Errorf("tag key %q must be 1 to %d characters", k, maxTagKeyLength)
+		}
+		if utf8.RuneCountInString(v) > maxTagValueLength {
+			return nil, fmt.Errorf("value of tag %q is longer than %d characters", k, maxTagValueLength)
+		}
+		if seen[k] {
+			return nil, fmt.Errorf("tag %q is given twice", k)
+		}
+		seen[k] = true
+		tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return tagSet, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// sha256MetadataKey is the metadata --checksum-metadata stores the hex
+// SHA-256 of a file under, read back by verify.
+const sha256MetadataKey = "sha256"
+
+// hashFile feeds the content of the file at path to h and returns the sum.
+func hashFile(path string, h hash.Hash) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	sum, err := hashFile(path, sha256.New())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// fileMD5 returns the MD5 of the file at path.
+func fileMD5(path string) ([]byte, error) {
+	return hashFile(path, md5.New())
+}
+
+// withPayloadSHA256 signs the request body with its precomputed hex SHA-256
+// instead of UNSIGNED-PAYLOAD. The signer picks the hash up from the context
+// and sends it as x-amz-content-sha256.
+func withPayloadSHA256(sum string) func(*s3.Options) {
+	return s3.WithAPIOptions(func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("PrecomputedPayloadSHA256", func(
+			ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler,
+		) (middleware.BuildOutput, middleware.Metadata, error) {
+			return next.HandleBuild(v4.SetPayloadHash(ctx, sum), in)
+		}), middleware.Before)
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/cuipeiyu/cloudflare-r2-uploader/r2"
+)
+
+// defaultRegion is the region R2 accepts for every bucket.
+const defaultRegion = r2.DefaultRegion
+
+var (
+	regionAutoDetect = false
+	requesterPays    = false
+
+	// pathStyle puts the bucket into the URL path (https://host/bucket/key)
+	// instead of the host name (https://bucket.host/key). R2 accepts both and
+	// defaults to virtual-hosted style, S3 compatible servers such as MinIO
+	// often only support path style.
+	pathStyle = false
+
+	// requestHeaders are the --upload-id-header values, sent with every request
+	requestHeaders []string
+
+	// expectedBucketOwner is sent as x-amz-expected-bucket-owner, so requests
+	// fail when the bucket belongs to another account
+	expectedBucketOwner = ""
+
+	// globalHeaders are the --global-header values, added to every request
+	// after it has been signed
+	globalHeaders []string
+
+	// responseHeaderTimeout bounds the wait for the response headers once the
+	// request has been sent
+	responseHeaderTimeout = 30 * time.Second
+)
+
+// requestPayer is set on object requests so that requester-pays buckets
+// accept them when --request-payer is given.
+func requestPayer() types.RequestPayer {
+	if requesterPays {
+		return types.RequestPayerRequester
+	}
+	return ""
+}
+
+// newR2Client builds an S3 client talking to the R2 endpoint of the
+// configured account, with the r2 package the command shares with other
+// programs.
+func newR2Client(ctx context.Context) (*s3.Client, error) {
+	headers, err := headerOptions(requestHeaders, false)
+	if err != nil {
+		return nil, err
+	}
+	unsigned, err := headerOptions(globalHeaders, true)
+	if err != nil {
+		return nil, err
+	}
+	headers = append(headers, unsigned...)
+	if expectedBucketOwner != "" {
+		// the same header the ExpectedBucketOwner field of every input sets
+		headers = append(headers, smithyhttp.SetHeaderValue("X-Amz-Expected-Bucket-Owner", expectedBucketOwner))
+	}
+
+	cfg := r2.Config{
+		AccountID:       accountId,
+		AccessKeyID:     accessKeyId,
+		SecretAccessKey: accessKeySecret,
+		Bucket:          bucketName,
+		UsePathStyle:    pathStyle,
+		// retries are done by withRetry, which knows how to rewind file bodies
+		Retryer:    func() aws.Retryer { return aws.NopRetryer{} },
+		APIOptions: headers,
+		HTTPClient: awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.ResponseHeaderTimeout = responseHeaderTimeout
+		}),
+	}
+	client, err := r2.NewClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if regionAutoDetect {
+		cfg.Region = detectRegion(ctx, client.S3)
+		debugf("Using region %s for bucket %s", cfg.Region, bucketName)
+
+		client, err = r2.NewClient(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return client.S3, nil
+}
+
+// headerOptions turns name=value pairs into middleware adding the headers to
+// every request. Repeating a name sends the header several times. Headers
+// added afterSigning are not covered by the signature.
+func headerOptions(pairs []string, afterSigning bool) ([]func(*middleware.Stack) error, error) {
+	options := make([]func(*middleware.Stack) error, 0, len(pairs))
+	for i, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected name=value", pair)
+		}
+		for _, c := range name {
+			if !isTokenChar(c) {
+				return nil, fmt.Errorf("invalid character %q in header name %q", c, name)
+			}
+		}
+		if afterSigning {
+			options = append(options, addUnsignedHeader(fmt.Sprintf("GlobalHeader%d", i), name, value))
+		} else {
+			options = append(options, smithyhttp.AddHeaderValue(name, value))
+		}
+	}
+	return options, nil
+}
+
+// addUnsignedHeader adds a header right after the request has been signed.
+func addUnsignedHeader(id, name, value string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc(id, func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				req.Header.Add(name, value)
+			}
+			return next.HandleFinalize(ctx, in)
+		}), "Signing", middleware.After)
+	}
+}
+
+// ensureBucket creates the configured bucket when HeadBucket reports that it
+// does not exist. It asks first when --confirm-before-delete is active.
+func ensureBucket(ctx context.Context, client *s3.Client) error {
+	err := withRetry(ctx, func() error {
+		_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{
+			Bucket: aws.String(bucketName),
+		})
+		return err
+	})
+	if !isNotFound(err) {
+		return err
+	}
+
+	if confirmBeforeDelete && !assumeYes && !confirm(fmt.Sprintf("Bucket %s does not exist, create it?", bucketName)) {
+		return fmt.Errorf("bucket %s does not exist", bucketName)
+	}
+
+	log.Printf("Creating bucket %s", bucketName)
+	return withRetry(ctx, func() error {
+		_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{
+			Bucket: aws.String(bucketName),
+		})
+		return err
+	})
+}
+
+// detectRegion asks R2 for the location of the bucket, falling back to "auto"
+// when GetBucketLocation is not supported or returns nothing.
+func detectRegion(ctx context.Context, client *s3.Client) string {
+	var out *s3.GetBucketLocationOutput
+	err := withRetry(ctx, func() (err error) {
+		out, err = client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+			Bucket: aws.String(bucketName),
+		}, func(o *s3.Options) {
+			if o.Region == "" {
+				o.Region = defaultRegion
+			}
+		})
+		return err
+	})
+	if err != nil {
+		debugf("GetBucketLocation failed, using region %s: %s", defaultRegion, err)
+		return defaultRegion
+	}
+
+	if out.LocationConstraint == "" {
+		return defaultRegion
+	}
+	return string(out.LocationConstraint)
+}
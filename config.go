@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	configFile  = ""
+	envFile     = ""
+	printConfig = false
+
+	// profile selects a section below profiles: of the config file
+	profile = ""
+
+	// generateConfig is the path --generate-config writes a starter config to
+	generateConfig = ""
+
+	// contentTypes maps lower case file extensions without the dot to the
+	// content type of the content_types: section of the config file
+	contentTypes = map[string]string{}
+)
+
+// configPlaceholder stands in for secrets in a generated config file.
+const configPlaceholder = "<YOUR_VALUE_HERE>"
+
+// configFlagsKey is the section of the config file with the flag values of
+// every command, as flags.<command>.<flag>.
+const configFlagsKey = "flags"
+
+// configMetaFlags choose the configuration itself, they are neither written
+// to nor read from the config file.
+var configMetaFlags = map[string]bool{
+	"help":            true,
+	"config":          true,
+	"profile":         true,
+	"env-file":        true,
+	"print-config":    true,
+	"generate-config": true,
+}
+
+// sensitiveFlag reports whether the flag name holds a secret, which a
+// generated config file leaves as a placeholder.
+func sensitiveFlag(name string) bool {
+	return strings.Contains(name, "secret") || strings.Contains(name, "token") || strings.Contains(name, "password")
+}
+
+// applyConfigFlags sets the flags of cmd from the flags.<command> section of
+// the config file. The command line wins, over the flag itself or another
+// flag of its mutually exclusive group.
+func applyConfigFlags(cmd *cobra.Command) error {
+	key := configFlagsKey + "." + cmd.Name()
+	values := viper.GetStringMap(key)
+	// sorted, so the excludes of the file come before its includes
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := values[name]
+		f := cmd.Flags().Lookup(name)
+		if f == nil || configMetaFlags[name] {
+			return fmt.Errorf("unknown flag %q in %s of the config file %s", name, key, viper.ConfigFileUsed())
+		}
+		if f.Changed || exclusiveFlagChanged(cmd, f) {
+			continue
+		}
+
+		var err error
+		if list, ok := value.([]any); ok {
+			s, ok := f.Value.(pflag.SliceValue)
+			if !ok {
+				return fmt.Errorf("%s.%s of the config file is a list, --%s takes a single value", key, name, name)
+			}
+			values := make([]string, len(list))
+			for i, v := range list {
+				values[i] = fmt.Sprint(v)
+			}
+			err = s.Replace(values)
+			f.Changed = true
+		} else {
+			err = cmd.Flags().Set(name, fmt.Sprint(value))
+		}
+		if err != nil {
+			return fmt.Errorf("%s.%s of the config file: %w", key, name, err)
+		}
+	}
+	return nil
+}
+
+// exclusiveFlagChanged reports whether the command line set another flag of
+// a mutually exclusive group of f.
+func exclusiveFlagChanged(cmd *cobra.Command, f *pflag.Flag) bool {
+	for _, group := range f.Annotations["cobra_annotation_mutually_exclusive"] {
+		for _, name := range strings.Split(group, " ") {
+			if other := cmd.Flags().Lookup(name); other != nil && other != f && other.Changed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadConfig reads the config file, if any, and the CFR2_* environment
+// variables, including those of --env-file, into the global settings.
+// Environment variables win over the --profile section of the file, which
+// wins over its top level. Without --config, .cfr2.yaml (or .toml, .json,
+// ...) is looked up in the current directory first, then in the home
+// directory and last as cfr2/config.yaml in the user config directory, e.g.
+// ~/.config/cfr2/config.yaml.
+func loadConfig() {
+	if envFile != "" {
+		if err := loadEnvFile(envFile); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	viper.SetEnvPrefix("CFR2")
+	viper.AutomaticEnv()
+
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName(".cfr2")
+		viper.AddConfigPath(".")
+		if home, err := os.UserHomeDir(); err == nil {
+			viper.AddConfigPath(home)
+		}
+	}
+
+	err := viper.ReadInConfig()
+	var notFound viper.ConfigFileNotFoundError
+	if configFile == "" && errors.As(err, &notFound) {
+		if path := userConfigFile(); path != "" {
+			viper.SetConfigFile(path)
+			err = viper.ReadInConfig()
+		}
+	}
+	if err != nil {
+		if configFile != "" || !errors.As(err, &notFound) {
+			log.Fatalln(err)
+		}
+	} else {
+		checkConfigPermissions(viper.ConfigFileUsed())
+	}
+
+	if profile == "" {
+		profile = viper.GetString("PROFILE")
+	}
+	if profile != "" && !viper.IsSet("profiles."+profile) {
+		log.Fatalf("profile %q not found in the config file %s", profile, viper.ConfigFileUsed())
+	}
+
+	bucketName = setting("BUCKET")
+	accountId = setting("ACCOUNT_ID")
+	accessKeyId = setting("ACCESSKEY")
+	accessKeySecret = setting("SECRETKEY")
+
+	contentTypes = contentTypeSetting()
+}
+
+// contentTypeSetting reads the content_types: extension to content type
+// mapping of the config file, the --profile section adding to and overriding
+// the top level one.
+func contentTypeSetting() map[string]string {
+	types := map[string]string{}
+	keys := []string{"content_types"}
+	if profile != "" {
+		keys = append(keys, "profiles."+profile+".content_types")
+	}
+	for _, key := range keys {
+		for ext, mimeType := range viper.GetStringMapString(key) {
+			types[strings.ToLower(strings.TrimPrefix(ext, "."))] = mimeType
+		}
+	}
+	return types
+}
+
+// setting returns the CFR2_<key> environment variable if it is not empty,
+// else key from the --profile section of the config file, else key from its
+// top level.
+func setting(key string) string {
+	if v := os.Getenv("CFR2_" + key); v != "" {
+		return v
+	}
+	if profile != "" {
+		if v := viper.GetString("profiles." + profile + "." + strings.ToLower(key)); v != "" {
+			return v
+		}
+	}
+	return viper.GetString(key)
+}
+
+// userConfigFile returns the config file in the cfr2 directory of the user
+// config directory, or "" if there is none.
+func userConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	for _, ext := range viper.SupportedExts {
+		path := filepath.Join(dir, "cfr2", "config."+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// printResolvedConfig writes the settings cmd would run with to stderr, with
+// the credentials redacted.
+func printResolvedConfig(cmd *cobra.Command) {
+	source := viper.ConfigFileUsed()
+	if source == "" {
+		source = "(none)"
+	}
+
+	w := os.Stderr
+	fmt.Fprintf(w, "config file: %s\n", source)
+	if profile != "" {
+		fmt.Fprintf(w, "profile:     %s\n", profile)
+	}
+	fmt.Fprintf(w, "bucket:      %s\n", bucketName)
+	fmt.Fprintf(w, "account_id:  %s\n", accountId)
+	fmt.Fprintf(w, "endpoint:    https://%s.r2.cloudflarestorage.com\n", accountId)
+	fmt.Fprintf(w, "accesskey:   %s\n", redacted(accessKeyId))
+	fmt.Fprintf(w, "secretkey:   %s\n", redacted(accessKeySecret))
+
+	fmt.Fprintf(w, "flags of %s:\n", cmd.CommandPath())
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" {
+			return
+		}
+		origin := "default"
+		if f.Changed {
+			origin = "set"
+		}
+		fmt.Fprintf(w, "  --%s=%s (%s)\n", f.Name, f.Value, origin)
+	})
+}
+
+// writeStarterConfig writes a commented config file with the current
+// settings to path, replacing the credentials with configPlaceholder. The
+// flags of cmd go below flags.<command>: the ones set on the command line as
+// values, the defaults commented out.
+func writeStarterConfig(path string, cmd *cobra.Command) error {
+	valueOr := func(v string) string {
+		if v == "" {
+			return configPlaceholder
+		}
+		return v
+	}
+
+	fields := []struct {
+		key     string
+		comment string
+		value   string
+	}{
+		{"bucket", "Name of the R2 bucket, CFR2_BUCKET", valueOr(bucketName)},
+		{"account_id", "Cloudflare account ID, part of the endpoint https://<account_id>.r2.cloudflarestorage.com, CFR2_ACCOUNT_ID", valueOr(accountId)},
+		{"accesskey", "Access key ID of an R2 API token, CFR2_ACCESSKEY", configPlaceholder},
+		{"secretkey", "Secret access key of the R2 API token, CFR2_SECRETKEY", configPlaceholder},
+	}
+
+	var b strings.Builder
+	b.WriteString("# cloudflare-r2-uploader configuration, use with --config.\n")
+	b.WriteString("# Environment variables override the values in this file.\n")
+	for _, field := range fields {
+		fmt.Fprintf(&b, "\n# %s\n%s: %s\n", field.comment, field.key, strconv.Quote(field.value))
+	}
+	b.WriteString("\n# Named profiles, selected with --profile or CFR2_PROFILE, override the values\n")
+	b.WriteString("# above for other accounts or buckets:\n")
+	b.WriteString("# profiles:\n")
+	b.WriteString("#   staging:\n")
+	b.WriteString("#     bucket: \"staging-assets\"\n")
+	b.WriteString("#     account_id: \"...\"\n")
+	b.WriteString("\n# Content types of file extensions, used before the built-in ones:\n")
+	b.WriteString("# content_types:\n")
+	b.WriteString("#   wasm: \"application/wasm\"\n")
+
+	fmt.Fprintf(&b, "\n# Flags of %s, used where the command line does not set them. The\n", cmd.CommandPath())
+	b.WriteString("# defaults are commented out, uncomment one to pin its value.\n")
+	fmt.Fprintf(&b, "%s:\n  %s:\n", configFlagsKey, cmd.Name())
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if configMetaFlags[f.Name] || f.Hidden || f.Deprecated != "" {
+			return
+		}
+		value := configFlagValue(f)
+		prefix := ""
+		switch {
+		case sensitiveFlag(f.Name) && f.Changed:
+			value, prefix = strconv.Quote(configPlaceholder), "# "
+		case !f.Changed:
+			prefix = "# "
+		}
+		fmt.Fprintf(&b, "\n    # %s\n    %s%s: %s\n", strings.ReplaceAll(f.Usage, "\n", " "), prefix, f.Name, value)
+	})
+
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(path, 0o600)
+}
+
+// configFlagValue formats the value of f for the config file, a list for
+// the flags that can be repeated.
+func configFlagValue(f *pflag.Flag) string {
+	s, ok := f.Value.(pflag.SliceValue)
+	if !ok {
+		return strconv.Quote(f.Value.String())
+	}
+	var values []string
+	for _, v := range s.GetSlice() {
+		values = append(values, strconv.Quote(v))
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+// redacted hides a secret, only telling whether it is set.
+func redacted(secret string) string {
+	if secret == "" {
+		return "(not set)"
+	}
+	return "****"
+}
+
+// checkConfigPermissions warns when a config file holding secrets can be read
+// by other users.
+func checkConfigPermissions(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		log.Printf("Warning: config file %s has permissions %#o, it should be 0600 since it contains secrets", path, perm)
+	}
+}
+
+// defaultConfigPath is where configure writes to when --config is not set.
+func defaultConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".cfr2.yaml"), nil
+}
+
+func configureCmd() *cobra.Command {
+	configure := &cobra.Command{
+		Use:   "configure",
+		Short: "write the R2 credentials to a config file",
+		Long:  "",
+		Args:  cobra.NoArgs,
+		// the credentials are not required yet, this is where they are set
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			loadConfig()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if profile != "" {
+				log.Fatalln("configure only writes the top-level settings, add the profiles to the config file by hand")
+			}
+
+			path := configFile
+			if path == "" {
+				var err error
+				path, err = defaultConfigPath()
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			in := bufio.NewReader(os.Stdin)
+			fields := []struct {
+				key    string
+				prompt string
+				value  string
+				secret bool
+			}{
+				{"bucket", "Bucket", bucketName, false},
+				{"account_id", "Account ID", accountId, false},
+				{"accesskey", "Access Key", accessKeyId, false},
+				{"secretkey", "Secret Key", accessKeySecret, true},
+			}
+
+			var b strings.Builder
+			for _, field := range fields {
+				value, err := prompt(in, field.prompt, field.value, field.secret)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				fmt.Fprintf(&b, "%s: %s\n", field.key, strconv.Quote(value))
+			}
+
+			if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+				log.Fatalln(err)
+			}
+			// WriteFile keeps the mode of an existing file
+			if err := os.Chmod(path, 0o600); err != nil {
+				log.Fatalln(err)
+			}
+
+			log.Printf("Configuration written to %s", path)
+		},
+	}
+
+	return configure
+}
+
+// prompt asks for a value on the terminal, keeping current when the answer is
+// empty. The current value of a secret is not echoed.
+func prompt(in *bufio.Reader, label, current string, secret bool) (string, error) {
+	switch {
+	case current != "" && secret:
+		fmt.Fprintf(os.Stderr, "%s [****]: ", label)
+	case current != "":
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", label, current)
+	default:
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+	}
+
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", err
+	}
+
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return current, nil
+	}
+	return answer, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// configTestCmd returns a command with flags of the kinds written to the
+// config file.
+func configTestCmd() (*cobra.Command, *pathRules) {
+	var filters pathRules
+	cmd := &cobra.Command{Use: "upload"}
+	cmd.Flags().Int("parallel", 4, "Files at the same time.")
+	cmd.Flags().Int64("part-size", 16<<20, "Part size in bytes.")
+	cmd.Flags().Int64("part-size-mb", 16, "Part size in MiB.")
+	cmd.MarkFlagsMutuallyExclusive("part-size", "part-size-mb")
+	cmd.Flags().StringArray("cache-control", nil, "Cache-Control, can be repeated.")
+	cmd.Flags().Var(ruleFlag{rules: &filters}, "exclude", "Skip matching files.")
+	cmd.Flags().Var(ruleFlag{rules: &filters, include: true}, "include", "Upload matching files.")
+	cmd.Flags().String("signed-header-secret", "", "Secret of the signature.")
+	return cmd, &filters
+}
+
+func TestStarterConfigRoundTrip(t *testing.T) {
+	defer viper.Reset()
+
+	cmd, _ := configTestCmd()
+	err := cmd.ParseFlags([]string{
+		"--parallel", "8",
+		"--part-size-mb", "32",
+		"--cache-control", "max-age=60", "--cache-control", "*.html=no-cache",
+		"--exclude", "*.map", "--include", "keep.map", "--exclude", "node_modules",
+		"--signed-header-secret", "s3cr3t",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := writeStarterConfig(path, cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Reset()
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatalf("the starter config does not load: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantMB  string
+		wantSet bool // whether --part-size-mb comes from the file
+	}{
+		{name: "from the file", wantMB: "32", wantSet: true},
+		{name: "exclusive flag on the command line", args: []string{"--part-size", "6000000"}, wantMB: "16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, filters := configTestCmd()
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyConfigFlags(cmd); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := cmd.Flags().Lookup("parallel").Value.String(); got != "8" {
+				t.Errorf("--parallel = %s, want 8", got)
+			}
+			if f := cmd.Flags().Lookup("part-size-mb"); f.Value.String() != tt.wantMB || f.Changed != tt.wantSet {
+				t.Errorf("--part-size-mb = %s (changed %v), want %s (changed %v)", f.Value, f.Changed, tt.wantMB, tt.wantSet)
+			}
+			if got, _ := cmd.Flags().GetStringArray("cache-control"); len(got) != 2 || got[1] != "*.html=no-cache" {
+				t.Errorf("--cache-control = %q", got)
+			}
+			want := pathRules{{pattern: "*.map"}, {pattern: "node_modules"}, {pattern: "keep.map", include: true}}
+			if len(*filters) != len(want) {
+				t.Fatalf("rules = %v, want %v", *filters, want)
+			}
+			for i := range want {
+				if (*filters)[i] != want[i] {
+					t.Errorf("rule %d = %v, want %v", i, (*filters)[i], want[i])
+				}
+			}
+			// the secret stays a commented out placeholder
+			if got := cmd.Flags().Lookup("signed-header-secret").Value.String(); got != "" {
+				t.Errorf("--signed-header-secret = %q, want it left out of the file", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	confirmBeforeDelete = isTerminal(os.Stdin)
+	assumeYes           = false
+)
+
+// confirmDelete lists the keys about to be deleted and asks for confirmation
+// on the terminal. It returns true without prompting when --yes is set or
+// --confirm-before-delete is off.
+func confirmDelete(keys []string) bool {
+	if len(keys) == 0 || assumeYes || !confirmBeforeDelete {
+		return true
+	}
+
+	for _, key := range keys {
+		fmt.Fprintln(os.Stderr, key)
+	}
+	return confirm(fmt.Sprintf("Delete %d objects?", len(keys)))
+}
+
+// confirm prints question followed by [y/N] and reads the answer from stdin.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
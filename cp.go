@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/cobra"
+)
+
+// remoteCopy is one CopyObject of cp or mv.
+type remoteCopy struct {
+	src, dest string
+}
+
+// planRemoteCopies maps the source of cp or mv to the keys to copy. A single
+// key copied to a destination ending in "/" keeps its name below it. With
+// recursive every object below the source prefix is copied below the
+// destination prefix, listed page by page.
+func planRemoteCopies(ctx context.Context, client *s3.Client, src, dest string, recursive bool) ([]remoteCopy, error) {
+	if !recursive {
+		if strings.HasSuffix(dest, "/") || dest == "" {
+			dest += path.Base(src)
+		}
+		return []remoteCopy{{src: src, dest: dest}}, nil
+	}
+
+	if src != "" && !strings.HasSuffix(src, "/") {
+		src += "/"
+	}
+	if dest != "" && !strings.HasSuffix(dest, "/") {
+		dest += "/"
+	}
+
+	keys, err := listKeys(ctx, client, src)
+	if err != nil {
+		return nil, err
+	}
+	copies := make([]remoteCopy, 0, len(keys))
+	for _, key := range keys {
+		copies = append(copies, remoteCopy{src: key, dest: dest + strings.TrimPrefix(key, src)})
+	}
+	return copies, nil
+}
+
+// planManifestCopies maps the paths of the manifest at manifestPath below
+// the source prefix to the same paths below the destination prefix. A signed
+// manifest is verified before anything is copied.
+func planManifestCopies(manifestPath, src, dest string) ([]remoteCopy, error) {
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	copies := make([]remoteCopy, 0, len(paths))
+	for _, p := range paths {
+		copies = append(copies, remoteCopy{
+			src:  strings.TrimPrefix(path.Join(src, p), "/"),
+			dest: strings.TrimPrefix(path.Join(dest, p), "/"),
+		})
+	}
+	return copies, nil
+}
+
+// copyRemote runs the copies on parallel workers and returns the first
+// error, once every copy has been tried. A non-empty ifMatch only copies
+// sources whose ETag it is, a copy fails with 412 Precondition Failed
+// otherwise.
+func copyRemote(ctx context.Context, client *s3.Client, copies []remoteCopy, parallel int, ifMatch string, logf func(string, ...any)) error {
+	var (
+		mu       sync.Mutex
+		done     int
+		firstErr error
+	)
+	pool := newUploadPool(parallel, func(src, dest string) {
+		err := withRetry(ctx, func() error {
+			input := &s3.CopyObjectInput{
+				Bucket:       aws.String(bucketName),
+				Key:          aws.String(dest),
+				CopySource:   aws.String(copySource(src)),
+				RequestPayer: requestPayer(),
+			}
+			if ifMatch != "" {
+				input.CopySourceIfMatch = aws.String(ifMatch)
+			}
+			_, err := client.CopyObject(ctx, input)
+			return err
+		})
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			log.Printf("Failed to copy %s to %s: %s", src, dest, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		done++
+		logf("Copied [% 4d] %s to %s", done, src, dest)
+	})
+	for _, c := range copies {
+		if ctx.Err() != nil {
+			break
+		}
+		pool.add(c.src, c.dest)
+	}
+	pool.wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+// remoteCopyCmd builds cp, or mv when move is set, which deletes the sources
+// once every copy succeeded. cp is also available as copy, the name of the
+// command that copied the objects of a manifest before --from-manifest.
+func remoteCopyCmd(move bool) *cobra.Command {
+	use, short, verb, past := "cp <source> <destination>", "copy an object, a prefix or the objects of a manifest to another key on the server", "copy", "Copied"
+	aliases := []string{"copy"}
+	if move {
+		use, short, verb, past = "mv <source> <destination>", "move or rename an object, a prefix or the objects of a manifest on the server", "move", "Moved"
+		aliases = nil
+	}
+
+	cmd := &cobra.Command{
+		Use:         use,
+		Aliases:     aliases,
+		Annotations: map[string]string{dryRunAnnotation: "true"},
+		Short:       short,
+		Long:        "",
+		Args:        cobra.RangeArgs(0, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			recursive, _ := cmd.Flags().GetBool("recursive")
+			fromManifest, _ := cmd.Flags().GetString("from-manifest")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			ifMatch, _ := cmd.Flags().GetString("copy-source-if-match")
+
+			if parallel < 1 {
+				log.Fatalln("--parallel must be at least 1")
+			}
+			// the ETag of one source object
+			if ifMatch != "" && (recursive || fromManifest != "") {
+				log.Fatalln("--copy-source-if-match only applies to a single key, it cannot be combined with --recursive or --from-manifest")
+			}
+
+			var src, dest string
+			switch {
+			case len(args) == 2:
+				src = strings.TrimLeft(args[0], "/")
+				dest = strings.TrimLeft(args[1], "/")
+			case fromManifest != "" && len(args) == 0:
+				// the flags of the former copy command
+				src, _ = cmd.Flags().GetString("source-prefix")
+				dest, _ = cmd.Flags().GetString("dest-prefix")
+			default:
+				log.Fatalf("%s needs a source and a destination", cmd.CommandPath())
+			}
+
+			if fromManifest != "" {
+				if recursive {
+					log.Fatalln("--from-manifest and --recursive cannot be combined")
+				}
+				// the manifest paths are below the source prefix
+				src, dest = strings.Trim(src, "/"), strings.Trim(dest, "/")
+			} else if !recursive && (src == "" || strings.HasSuffix(src, "/")) {
+				log.Fatalf("\"%s\" is a prefix, use --recursive to %s the objects below it", src, verb)
+			}
+			if strings.TrimSuffix(src, "/") == strings.TrimSuffix(dest, "/") {
+				log.Fatalln("the destination must differ from the source")
+			}
+			// the copies would be listed again as sources, or deleted
+			if (recursive || fromManifest != "") && move && (strings.HasPrefix(dest, strings.TrimSuffix(src, "/")+"/") || src == "") {
+				log.Fatalln("cannot move a prefix into itself")
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var copies []remoteCopy
+			if fromManifest != "" {
+				copies, err = planManifestCopies(fromManifest, src, dest)
+			} else {
+				copies, err = planRemoteCopies(ctx, client, src, dest, recursive)
+			}
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if len(copies) == 1 && copies[0].src == copies[0].dest {
+				log.Fatalln("the destination must differ from the source")
+			}
+
+			if dryRun {
+				for _, c := range copies {
+					log.Printf("Would %s %s to %s", verb, c.src, c.dest)
+				}
+				log.Printf("Would %s %d objects", verb, len(copies))
+				return
+			}
+			if len(copies) == 0 {
+				log.Printf("Nothing to %s below %s", verb, src)
+				return
+			}
+
+			logf := func(format string, v ...any) {
+				if !quiet {
+					log.Printf(format, v...)
+				}
+			}
+			if err := copyRemote(ctx, client, copies, parallel, ifMatch, logf); err != nil {
+				if move {
+					log.Fatalf("%s, no source was deleted", err)
+				}
+				log.Fatalln(err)
+			}
+
+			if move {
+				sources := make([]string, len(copies))
+				for i, c := range copies {
+					sources[i] = c.src
+				}
+				if err := deleteObjects(ctx, client, sources, maxDeleteObjects); err != nil {
+					log.Fatalf("the objects were copied, deleting the sources failed: %s", err)
+				}
+			}
+
+			log.Printf("%s %d objects to %s", past, len(copies), dest)
+		},
+	}
+
+	cmd.Flags().BoolP("recursive", "r", false, "Take every object below the source prefix, keeping its path below the destination prefix.")
+	cmd.Flags().String("from-manifest", "", "Take the paths listed in this manifest, as used by --require-manifest or written by --signed-manifest, below the source prefix and keep them below the destination prefix.")
+	cmd.Flags().String("source-prefix", "", "Source prefix of --from-manifest when no source and destination are given.")
+	cmd.Flags().String("dest-prefix", "", "Destination prefix of --from-manifest when no source and destination are given.")
+	cmd.Flags().MarkDeprecated("source-prefix", "give the source prefix as the first argument")
+	cmd.Flags().MarkDeprecated("dest-prefix", "give the destination prefix as the second argument")
+	cmd.Flags().String("copy-source-if-match", "", "Only "+verb+" the source if its ETag is this value (x-amz-copy-source-if-match), e.g. unchanged since it was last read. A single key only.")
+	cmd.Flags().Int("parallel", 8, "Number of objects copied at the same time.")
+	cmd.Flags().BoolP("quiet", "q", false, "Only print the final summary.")
+
+	return cmd
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// etagIndex maps the MD5 ETag of every object in the bucket to one of the
+// keys holding that content.
+type etagIndex map[string]string
+
+// loadETagIndex lists the whole bucket and indexes the objects by ETag.
+// Multipart ETags ("<md5>-<parts>") are not the MD5 of the content and are
+// left out.
+func loadETagIndex(ctx context.Context, client *s3.Client) (etagIndex, error) {
+	index := etagIndex{}
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:       aws.String(bucketName),
+		RequestPayer: requestPayer(),
+	})
+	for paginator.HasMorePages() {
+		var page *s3.ListObjectsV2Output
+		err := withRetry(ctx, func() (err error) {
+			page, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			index.add(aws.ToString(object.ETag), aws.ToString(object.Key))
+		}
+	}
+	return index, nil
+}
+
+// add records key under etag unless another key already holds the content.
+func (x etagIndex) add(etag, key string) {
+	etag = strings.Trim(etag, `"`)
+	if etag == "" || strings.Contains(etag, "-") {
+		return
+	}
+	if _, ok := x[etag]; !ok {
+		x[etag] = key
+	}
+}
+
+// duplicateOf returns the key of an object with the same content as the file
+// at path, or "" if there is none.
+func (u *uploader) duplicateOf(path string) (string, error) {
+	sum, err := fileMD5(path)
+	if err != nil {
+		return "", err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.dedup[hex.EncodeToString(sum)], nil
+}
+
+// dedupFile skips the upload of path because src already has its content,
+// copying src to key on the server with --dedup-copy.
+func (u *uploader) dedupFile(ctx context.Context, path, key, src string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if u.dryRun {
+		return u.planFile(ctx, path, key, true, "duplicate", info.Size())
+	}
+
+	if u.dedupCopy && src != key {
+		u.logf("\"%s\" is a duplicate of \"%s\", copying", key, src)
+
+		err := withRetry(ctx, func() error {
+			_, err := u.client.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:       aws.String(bucketName),
+				Key:          aws.String(key),
+				CopySource:   aws.String(copySource(src)),
+				RequestPayer: requestPayer(),
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		u.logf("\"%s\" is a duplicate of \"%s\" will be skipped", key, src)
+	}
+	if u.events != nil {
+		u.events.emit(progressEvent{Event: "skip", Path: path, Key: key, Reason: "duplicate"})
+	}
+
+	if u.compact != nil {
+		u.compact.skip(info.Size())
+	}
+
+	u.mu.Lock()
+	u.skipped++
+	u.mu.Unlock()
+	return nil
+}
+
+// copySource builds the x-amz-copy-source value of key in the bucket, with
+// every path segment URL-encoded.
+func copySource(key string) string {
+	return bucketName + "/" + escapeKey(key)
+}
+
+// escapeKey URL-encodes every path segment of key.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
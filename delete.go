@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/cobra"
+)
+
+func deleteCmd() *cobra.Command {
+	del := &cobra.Command{
+		Use:         "delete <key-or-prefix>",
+		Aliases:     []string{"rm"},
+		Annotations: map[string]string{dryRunAnnotation: "true"},
+		Short:       "delete an object or every object below a prefix",
+		Long:        "",
+		Args:        cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			recursive, _ := cmd.Flags().GetBool("recursive")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			batchSize, _ := cmd.Flags().GetInt("delete-batch-size")
+			versionID, _ := cmd.Flags().GetString("version-id")
+			requireVersionID, _ := cmd.Flags().GetBool("require-version-id")
+
+			if batchSize < 1 || batchSize > maxDeleteObjects {
+				log.Fatalf("--delete-batch-size must be between 1 and %d, the most keys DeleteObjects accepts", maxDeleteObjects)
+			}
+
+			// in a versioned bucket a delete without a version only adds a
+			// delete marker
+			if requireVersionID && versionID == "" {
+				log.Fatalln("--require-version-id is set, give the version to delete with --version-id")
+			}
+			if versionID != "" && recursive {
+				log.Fatalln("--version-id names a version of a single key, it cannot be combined with --recursive")
+			}
+
+			remotePath := strings.TrimLeft(args[0], "/")
+			if recursive && remotePath == "" && !assumeYes {
+				log.Fatalln("refusing to delete the whole bucket without --yes")
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var keys []string
+			if recursive {
+				prefix := remotePath
+				if prefix != "" && !strings.HasSuffix(prefix, "/") {
+					prefix += "/"
+				}
+				keys, err = listKeys(ctx, client, prefix)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			} else {
+				// DeleteObject succeeds for a key that does not exist
+				err := withRetry(ctx, func() error {
+					input := &s3.HeadObjectInput{
+						Bucket:       aws.String(bucketName),
+						Key:          aws.String(remotePath),
+						RequestPayer: requestPayer(),
+					}
+					if versionID != "" {
+						input.VersionId = aws.String(versionID)
+					}
+					_, err := client.HeadObject(ctx, input)
+					return err
+				})
+				if isNotFound(err) && versionID != "" {
+					log.Fatalf("version %s of \"%s\" does not exist", versionID, remotePath)
+				}
+				if isNotFound(err) {
+					log.Fatalf("\"%s\" does not exist, use --recursive to delete the objects below a prefix", remotePath)
+				}
+				if err != nil {
+					log.Fatalln(err)
+				}
+				keys = []string{remotePath}
+			}
+
+			if versionID != "" {
+				label := fmt.Sprintf("%s (version %s)", remotePath, versionID)
+				if dryRun {
+					log.Printf("Would delete %s", label)
+					return
+				}
+				if !confirmDelete([]string{label}) {
+					log.Println("Nothing deleted.")
+					return
+				}
+				err := withRetry(ctx, func() error {
+					_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+						Bucket:       aws.String(bucketName),
+						Key:          aws.String(remotePath),
+						VersionId:    aws.String(versionID),
+						RequestPayer: requestPayer(),
+					})
+					return err
+				})
+				if err != nil {
+					log.Fatalln(err)
+				}
+				log.Printf("Deleted %s", label)
+				return
+			}
+
+			if dryRun {
+				for _, key := range keys {
+					log.Printf("Would delete %s", key)
+				}
+				log.Printf("Would delete %d objects", len(keys))
+				return
+			}
+
+			if len(keys) == 0 || !confirmDelete(keys) {
+				log.Println("Nothing deleted.")
+				return
+			}
+
+			if !quiet {
+				log.Printf("Deleting %d objects from %s", len(keys), bucketName)
+			}
+			if err := deleteObjects(ctx, client, keys, batchSize); err != nil {
+				log.Fatalln(err)
+			}
+			log.Printf("Deleted %d objects", len(keys))
+		},
+	}
+
+	del.Flags().BoolP("recursive", "r", false, "Delete every object below the prefix, in batches of --delete-batch-size keys.")
+	del.Flags().Int("delete-batch-size", maxDeleteObjects, "Number of keys sent per DeleteObjects request, at most 1000. Lower it where the API is rate limited.")
+	del.Flags().String("version-id", "", "Delete this version of the key for good instead of adding a delete marker in a versioned bucket. A single key only.")
+	del.Flags().Bool("require-version-id", false, "Refuse to delete without --version-id, so a versioned bucket never gets a delete marker by accident.")
+	del.Flags().BoolP("quiet", "q", false, "Only print the final summary.")
+
+	return del
+}
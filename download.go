@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/spf13/cobra"
+)
+
+// partialSuffix is appended to the name of a file while --resume downloads
+// it.
+const partialSuffix = ".part"
+
+// downloader fetches objects from the configured bucket to local files.
+type downloader struct {
+	client *s3.Client
+
+	force        bool
+	quiet        bool
+	restoreMtime bool
+	// resume continues the partial files of an interrupted download
+	resume bool
+	// transferManager fetches the objects in parallel ranges with
+	// --download-manager
+	transferManager *manager.Downloader
+
+	downloaded int
+	skipped    int
+	bytes      int64
+}
+
+// downloadObject writes key to dest, creating the parent directories. An
+// existing dest is only overwritten with --force.
+func (d *downloader) downloadObject(ctx context.Context, key, dest string) error {
+	if !d.force {
+		if _, err := os.Stat(dest); err == nil {
+			if !d.quiet {
+				log.Printf("\"%s\" is exists will be skipped", dest)
+			}
+			d.skipped++
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+
+	if !d.quiet {
+		log.Printf("Downloading [% 4d] %s to %s", d.downloaded, key, dest)
+	}
+
+	// the object is written next to dest and renamed onto it once complete,
+	// so a failed download leaves an existing dest alone. --resume keeps
+	// the partial file for the next run.
+	tmp := dest + partialSuffix
+	if !d.resume {
+		file, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*"+partialSuffix)
+		if err != nil {
+			return err
+		}
+		tmp = file.Name()
+		file.Close()
+		if err := os.Chmod(tmp, 0o644); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	var (
+		size  int64
+		mtime time.Time
+		err   error
+	)
+	if d.transferManager != nil {
+		size, mtime, err = d.managerDownload(ctx, key, tmp)
+	} else {
+		size, mtime, err = d.getObject(ctx, key, tmp)
+	}
+	if err != nil {
+		// a partial file is kept for the next --resume
+		if !d.resume {
+			os.Remove(tmp)
+		}
+		if skipOnAccessDenied && isForbidden(err) {
+			log.Printf("Warning: access to \"%s\" denied, skipping it", key)
+			d.skipped++
+			return nil
+		}
+		return err
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		if !d.resume {
+			os.Remove(tmp)
+		}
+		return err
+	}
+
+	if d.restoreMtime && !mtime.IsZero() {
+		if err := os.Chtimes(dest, mtime, mtime); err != nil {
+			return err
+		}
+	}
+
+	d.downloaded++
+	d.bytes += size
+	return nil
+}
+
+// getObject writes key to path with GetObject, continuing a partial file
+// with --resume. It returns the size and modification time of the object.
+func (d *downloader) getObject(ctx context.Context, key, path string) (size int64, mtime time.Time, err error) {
+	err = withRetry(ctx, func() error {
+		input := &s3.GetObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+		}
+
+		// a retry continues the partial file as well
+		var offset int64
+		if d.resume {
+			if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+				offset = info.Size()
+				input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+				// an object replaced since the partial file was written starts over
+				input.IfUnmodifiedSince = aws.Time(info.ModTime())
+			}
+		}
+
+		out, err := d.client.GetObject(ctx, input)
+		if offset > 0 && isStalePartial(err) {
+			debugf("Discarding the partial download %s: %s", path, err)
+			offset = 0
+			input.Range, input.IfUnmodifiedSince = nil, nil
+			out, err = d.client.GetObject(ctx, input)
+		}
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if offset > 0 {
+			flags = os.O_WRONLY | os.O_APPEND
+			debugf("Resuming %s at byte %d", key, offset)
+		}
+		file, err := os.OpenFile(path, flags, 0o644)
+		if err != nil {
+			return err
+		}
+
+		var progress func(int64, int64)
+		if !d.quiet {
+			progress = newProgressPrinter(key).update
+		}
+
+		n, err := io.Copy(file, NewProgressReader(out.Body, out.ContentLength, progress))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		size = offset + n
+		mtime = objectMtime(out.Metadata, out.LastModified)
+		return err
+	})
+	return size, mtime, err
+}
+
+// objectMtime returns the modification time stored in the mtime metadata of
+// an object, as Unix seconds or RFC 3339, falling back to its LastModified.
+func objectMtime(metadata map[string]string, lastModified *time.Time) time.Time {
+	if v, ok := metadata["mtime"]; ok {
+		if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+		debugf("Ignoring invalid mtime metadata %q", v)
+	}
+	return aws.ToTime(lastModified)
+}
+
+// downloadPrefix downloads every object below prefix into dir, recreating the
+// directory structure from the keys. Keys ending in "/" are folder markers and
+// only create the directory.
+func (d *downloader) downloadPrefix(ctx context.Context, prefix, dir string) error {
+	paginator := s3.NewListObjectsV2Paginator(d.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		var page *s3.ListObjectsV2Output
+		err := withRetry(ctx, func() (err error) {
+			page, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+
+			dest, err := localPathFor(dir, strings.TrimPrefix(key, prefix))
+			if err != nil {
+				return err
+			}
+
+			if strings.HasSuffix(key, "/") {
+				if err := os.MkdirAll(dest, 0o755); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := d.downloadObject(ctx, key, dest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// localPathFor joins the relative key rel to dir and makes sure the result
+// does not escape dir through ".." elements.
+func localPathFor(dir, rel string) (string, error) {
+	dest := filepath.Join(dir, filepath.FromSlash(strings.TrimLeft(rel, "/")))
+
+	r, err := filepath.Rel(dir, dest)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %q escapes the destination directory", rel)
+	}
+	return dest, nil
+}
+
+// isStalePartial reports whether err rejects the Range request continuing a
+// partial download: the object changed since (412) or the partial file is
+// not shorter than the object (416).
+func isStalePartial(err error) bool {
+	var respErr *smithyhttp.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	code := respErr.HTTPStatusCode()
+	return code == 412 || code == 416
+}
+
+// isNotFound reports whether err is a 404 response, by its type, its API
+// error code or its status code.
+func isNotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey") {
+		return true
+	}
+
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == 404
+}
+
+func downloadCmd() *cobra.Command {
+	download := &cobra.Command{
+		Use:     "download <remote-key-or-prefix> <local-path>",
+		Aliases: []string{"get"},
+		Short:   "download an object or every object below a prefix",
+		Long:    "",
+		Args:    cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			force, _ := cmd.Flags().GetBool("force")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			restoreMtime, _ := cmd.Flags().GetBool("restore-mtime")
+			resume, _ := cmd.Flags().GetBool("resume")
+			useManager, _ := cmd.Flags().GetBool("download-manager")
+			managerConcurrency, _ := cmd.Flags().GetInt("download-concurrency")
+			managerPartSize, _ := cmd.Flags().GetInt64("download-part-size")
+
+			if useManager {
+				if resume {
+					log.Fatalln("--download-manager writes the ranges of a file out of order, it cannot be combined with --resume")
+				}
+				if managerPartSize < manager.MinUploadPartSize {
+					log.Fatalf("--download-part-size must be at least %d bytes", manager.MinUploadPartSize)
+				}
+				if managerConcurrency < 1 {
+					log.Fatalln("--download-concurrency must be at least 1")
+				}
+			}
+
+			remotePath := strings.TrimLeft(args[0], "/")
+			localPath := args[1]
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			d := &downloader{
+				client:       client,
+				force:        force,
+				quiet:        quiet,
+				restoreMtime: restoreMtime,
+				resume:       resume,
+			}
+			if useManager {
+				d.transferManager = newDownloadManager(client, managerPartSize, managerConcurrency)
+			}
+
+			if !quiet {
+				log.Printf("Download \"%s\" to \"%s\"", remotePath, localPath)
+			}
+
+			// a single object, unless the path names a folder
+			single := false
+			if remotePath != "" && !strings.HasSuffix(remotePath, "/") {
+				err := withRetry(ctx, func() error {
+					_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+						Bucket:       aws.String(bucketName),
+						Key:          aws.String(remotePath),
+						RequestPayer: requestPayer(),
+					})
+					return err
+				})
+				if err != nil && !isNotFound(err) && !(skipOnAccessDenied && isForbidden(err)) {
+					log.Fatalln(err)
+				}
+				// GetObject reports the denied access of a single object
+				single = err == nil || isForbidden(err)
+			}
+
+			if single {
+				dest := localPath
+				if info, err := os.Stat(localPath); (err == nil && info.IsDir()) || strings.HasSuffix(localPath, string(filepath.Separator)) {
+					dest = filepath.Join(localPath, path.Base(remotePath))
+				}
+
+				if err := d.downloadObject(ctx, remotePath, dest); err != nil {
+					log.Fatalln(err)
+				}
+			} else {
+				prefix := remotePath
+				if prefix != "" && !strings.HasSuffix(prefix, "/") {
+					prefix += "/"
+				}
+
+				if err := d.downloadPrefix(ctx, prefix, localPath); err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			log.Printf("Downloaded %d files (%s), skipped %d files", d.downloaded, formatBytes(d.bytes), d.skipped)
+		},
+	}
+
+	download.Flags().Bool("force", false, "Overwrite existing local files.")
+	download.Flags().BoolP("quiet", "q", false, "Suppress per-file progress and only print the final summary.")
+	download.Flags().Bool("resume", false, "Download into <file>.part and continue an existing partial file with a Range request, unless the object changed since.")
+	download.Flags().Bool("restore-mtime", false, "Set the modification time of downloaded files from their mtime metadata, or else from the object's Last-Modified.")
+	download.Flags().Bool("download-manager", false, "Fetch every object in ranges of --download-part-size, --download-concurrency at a time, with the download manager of the AWS SDK, writing each range at its offset.")
+	download.Flags().Int("download-concurrency", manager.DefaultDownloadConcurrency, "Number of ranges of one object the download manager fetches at the same time.")
+	download.Flags().Int64("download-part-size", manager.DefaultDownloadPartSize, "Size in bytes of the ranges of the download manager, at least 5 MiB.")
+
+	return download
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestDownloadObjectKeepsFileOnError(t *testing.T) {
+	defer func(retries int, failFast, skipDenied bool) {
+		maxRetries, failFastOnAuthError, skipOnAccessDenied = retries, failFast, skipDenied
+	}(maxRetries, failFastOnAuthError, skipOnAccessDenied)
+	maxRetries = 0
+	failFastOnAuthError = false
+
+	tests := []struct {
+		name       string
+		status     int
+		skipDenied bool
+		wantErr    bool
+	}{
+		{name: "500", status: 500, wantErr: true},
+		{name: "403", status: 403, wantErr: true},
+		{name: "403 skip on access denied", status: 403, skipDenied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skipOnAccessDenied = tt.skipDenied
+
+			dir := t.TempDir()
+			dest := filepath.Join(dir, "file.txt")
+			if err := os.WriteFile(dest, []byte("local"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			d := &downloader{client: headClient(statusError(tt.status)), force: true, quiet: true}
+			err := d.downloadObject(context.Background(), "file.txt", dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadObject() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			data, err := os.ReadFile(dest)
+			if err != nil || string(data) != "local" {
+				t.Errorf("dest = %q, %v after a failed download, want it unchanged", data, err)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("the directory has %d entries, want the temporary file removed", len(entries))
+			}
+		})
+	}
+}
+
+func TestDownloadManagerKeepsFileOnError(t *testing.T) {
+	defer func(retries int) { maxRetries = retries }(maxRetries)
+	maxRetries = 0
+
+	// HeadObject succeeds, the ranges fail
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "100")
+			w.Header().Set("ETag", `"etag"`)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	client := s3.New(s3.Options{
+		Region:           "auto",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		EndpointResolver: s3.EndpointResolverFromURL(srv.URL),
+		UsePathStyle:     true,
+		Retryer:          aws.NopRetryer{},
+	})
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(dest, []byte("local"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &downloader{client: client, force: true, quiet: true, transferManager: newDownloadManager(client, 5<<20, 2)}
+	if err := d.downloadObject(context.Background(), "file.txt", dest); err == nil {
+		t.Fatal("downloadObject() error = nil, want the GetObject error")
+	}
+
+	data, err := os.ReadFile(dest)
+	if err != nil || string(data) != "local" {
+		t.Errorf("dest = %q, %v after a failed download, want it unchanged", data, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("the directory has %d entries, want the temporary file removed", len(entries))
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envVar is one KEY=VALUE assignment of an --env-file.
+type envVar struct {
+	key   string
+	value string
+}
+
+// loadEnvFile sets the variables of the .env file at path in the process
+// environment. Variables that are already set keep their value, so the real
+// environment wins over the file.
+func loadEnvFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	vars, err := parseEnvFile(string(data))
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for _, v := range vars {
+		if _, ok := os.LookupEnv(v.key); ok {
+			continue
+		}
+		if err := os.Setenv(v.key, v.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseEnvFile parses the content of a .env file:
+//
+//	# comment
+//	export CFR2_BUCKET=assets
+//	CFR2_ACCOUNT_ID="0123\t456" # double quotes support \n, \t, \" and \\
+//	CFR2_SECRETKEY='taken $literally'
+//	CFR2_NOTE=first line \
+//	second line
+//
+// Quoted values may span several lines, an unquoted value ending with a
+// backslash continues on the next line: the backslash and the line break are
+// dropped, so CFR2_NOTE is "first line second line".
+func parseEnvFile(data string) ([]envVar, error) {
+	var vars []envVar
+
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validEnvKey(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value string
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			quote := rest[0]
+			body := rest[1:]
+			for {
+				end := closingQuote(body, quote)
+				if end >= 0 {
+					trailing := strings.TrimSpace(body[end+1:])
+					if trailing != "" && !strings.HasPrefix(trailing, "#") {
+						return nil, fmt.Errorf("line %d: unexpected %q after the closing quote", lineNo, trailing)
+					}
+					value = body[:end]
+					break
+				}
+				if i+1 >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated quoted value", lineNo)
+				}
+				i++
+				body += "\n" + lines[i]
+			}
+			if quote == '"' {
+				value = unescapeEnv(value)
+			}
+		} else {
+			for strings.HasSuffix(rest, `\`) && i+1 < len(lines) {
+				i++
+				rest = rest[:len(rest)-1] + strings.TrimSpace(lines[i])
+			}
+			if j := strings.Index(rest, " #"); j >= 0 {
+				rest = rest[:j]
+			}
+			value = strings.TrimSpace(rest)
+		}
+
+		vars = append(vars, envVar{key: key, value: value})
+	}
+	return vars, nil
+}
+
+func validEnvKey(key string) bool {
+	if key == "" || (key[0] >= '0' && key[0] <= '9') {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// closingQuote returns the index of the quote ending s, skipping escaped
+// characters inside double quotes, or -1.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeEnv resolves the escape sequences of a double quoted value. A
+// backslash before a line break joins the lines.
+func unescapeEnv(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '\n':
+		case '"', '\\', '$':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
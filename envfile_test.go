@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []envVar
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			data: "# comment\n\nCFR2_BUCKET=assets\nexport CFR2_ACCOUNT_ID = 0123 # the account\r\nEMPTY=\n",
+			want: []envVar{{"CFR2_BUCKET", "assets"}, {"CFR2_ACCOUNT_ID", "0123"}, {"EMPTY", ""}},
+		},
+		{
+			name: "double quotes",
+			data: `CFR2_ACCOUNT_ID="0123\t456 \"quoted\" \\ \$HOME # not a comment" # comment`,
+			want: []envVar{{"CFR2_ACCOUNT_ID", "0123\t456 \"quoted\" \\ $HOME # not a comment"}},
+		},
+		{
+			name: "single quotes",
+			data: `CFR2_SECRETKEY='taken $literally \n'`,
+			want: []envVar{{"CFR2_SECRETKEY", `taken $literally \n`}},
+		},
+		{
+			name: "quoted over several lines",
+			data: "KEY=\"first\nsecond\"\nNEXT=1",
+			want: []envVar{{"KEY", "first\nsecond"}, {"NEXT", "1"}},
+		},
+		{
+			name: "escaped line break in double quotes",
+			data: "KEY=\"first \\\nsecond\"",
+			want: []envVar{{"KEY", "first second"}},
+		},
+		{
+			name: "continued line",
+			data: "CFR2_NOTE=first line \\\n  second line\nNEXT=1",
+			want: []envVar{{"CFR2_NOTE", "first line second line"}, {"NEXT", "1"}},
+		},
+		{
+			name: "equals in the value",
+			data: "KEY=a=b",
+			want: []envVar{{"KEY", "a=b"}},
+		},
+		{name: "no equals", data: "CFR2_BUCKET", wantErr: true},
+		{name: "empty key", data: "=value", wantErr: true},
+		{name: "key starting with a digit", data: "1KEY=value", wantErr: true},
+		{name: "invalid key", data: "MY-KEY=value", wantErr: true},
+		{name: "unterminated quote", data: "KEY=\"value\nNEXT=1", wantErr: true},
+		{name: "text after the quote", data: `KEY="value" more`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvFile(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEnvFile() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseEnvFile() = %q, want %q", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("variable %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
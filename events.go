@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressEvent is one line of --progress json. Event is start, progress,
+// done, skip, error or summary, the other fields are set as they apply.
+type progressEvent struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Path   string    `json:"path,omitempty"`
+	Key    string    `json:"key,omitempty"`
+	Bytes  int64     `json:"bytes,omitempty"`
+	Total  int64     `json:"total,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Error  string    `json:"error,omitempty"`
+
+	// the totals of the summary event
+	Uploaded int     `json:"uploaded,omitempty"`
+	Skipped  int     `json:"skipped,omitempty"`
+	Failed   int     `json:"failed,omitempty"`
+	Seconds  float64 `json:"seconds,omitempty"`
+}
+
+// progressEvents writes line-delimited JSON events to stdout for --progress
+// json, one line per event so that other tools can follow the upload.
+type progressEvents struct {
+	// mu keeps the lines of parallel uploads apart
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newProgressEvents() *progressEvents {
+	return &progressEvents{enc: json.NewEncoder(os.Stdout)}
+}
+
+func (e *progressEvents) emit(event progressEvent) {
+	event.Time = time.Now().UTC()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(event)
+}
+
+// reader returns a callback for NewProgressReader that emits a progress event
+// for path at most every jsonProgressInterval.
+func (e *progressEvents) reader(path, key string) func(int64, int64) {
+	var last time.Time
+	done := false
+	return func(read, total int64) {
+		if done {
+			return
+		}
+		now := time.Now()
+		if read < total && now.Sub(last) < jsonProgressInterval {
+			return
+		}
+		last, done = now, read >= total
+		e.emit(progressEvent{Event: "progress", Path: path, Key: key, Bytes: read, Total: total})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// objectFilter holds the client-side filters of list.
+type objectFilter struct {
+	// etag is an exact ETag or a glob pattern, without the quotes
+	etag string
+
+	// minSize and maxSize bound the size in bytes, -1 means no bound
+	minSize int64
+	maxSize int64
+
+	// modifiedAfter and modifiedBefore bound LastModified when not zero
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+}
+
+// active reports whether any filter is set.
+func (f objectFilter) active() bool {
+	return f.etag != "" || f.minSize >= 0 || f.maxSize >= 0 ||
+		!f.modifiedAfter.IsZero() || !f.modifiedBefore.IsZero()
+}
+
+// match reports whether object passes every filter.
+func (f objectFilter) match(object types.Object) bool {
+	if f.etag != "" {
+		etag := strings.Trim(aws.ToString(object.ETag), `"`)
+		if ok, _ := path.Match(f.etag, etag); !ok {
+			return false
+		}
+	}
+	if f.minSize >= 0 && object.Size < f.minSize {
+		return false
+	}
+	if f.maxSize >= 0 && object.Size > f.maxSize {
+		return false
+	}
+
+	modified := aws.ToTime(object.LastModified)
+	if !f.modifiedAfter.IsZero() && !modified.After(f.modifiedAfter) {
+		return false
+	}
+	if !f.modifiedBefore.IsZero() && !modified.Before(f.modifiedBefore) {
+		return false
+	}
+	return true
+}
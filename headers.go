@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// objectHeaders holds the optional HTTP headers and user metadata that are
+// attached to an uploaded object.
+type objectHeaders struct {
+	CacheControl       string            `json:"Cache-Control,omitempty"`
+	ContentDisposition string            `json:"Content-Disposition,omitempty"`
+	ContentEncoding    string            `json:"Content-Encoding,omitempty"`
+	ACL                string            `json:"ACL,omitempty"`
+	Metadata           map[string]string `json:"Metadata,omitempty"`
+}
+
+// merge returns a copy of h with every non-empty field of o taking precedence.
+func (h objectHeaders) merge(o objectHeaders) objectHeaders {
+	if o.CacheControl != "" {
+		h.CacheControl = o.CacheControl
+	}
+	if o.ContentDisposition != "" {
+		h.ContentDisposition = o.ContentDisposition
+	}
+	if o.ContentEncoding != "" {
+		h.ContentEncoding = o.ContentEncoding
+	}
+	if o.ACL != "" {
+		h.ACL = o.ACL
+	}
+	if len(o.Metadata) > 0 {
+		metadata := make(map[string]string, len(h.Metadata)+len(o.Metadata))
+		for k, v := range h.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range o.Metadata {
+			metadata[k] = v
+		}
+		h.Metadata = metadata
+	}
+	return h
+}
+
+func (h objectHeaders) apply(input *s3.PutObjectInput) {
+	if h.CacheControl != "" {
+		input.CacheControl = aws.String(h.CacheControl)
+	}
+	if h.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(h.ContentDisposition)
+	}
+	if h.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(h.ContentEncoding)
+	}
+	if h.ACL != "" {
+		input.ACL = types.ObjectCannedACL(h.ACL)
+	}
+	if len(h.Metadata) > 0 {
+		input.Metadata = h.Metadata
+	}
+}
+
+// applyMultipart is apply for the start of a multipart upload, the parts
+// carry no headers of their own.
+func (h objectHeaders) applyMultipart(input *s3.CreateMultipartUploadInput) {
+	if h.CacheControl != "" {
+		input.CacheControl = aws.String(h.CacheControl)
+	}
+	if h.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(h.ContentDisposition)
+	}
+	if h.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(h.ContentEncoding)
+	}
+	if h.ACL != "" {
+		input.ACL = types.ObjectCannedACL(h.ACL)
+	}
+	if len(h.Metadata) > 0 {
+		input.Metadata = h.Metadata
+	}
+}
+
+// validate checks the ACL and metadata before any upload begins, so a typo
+// does not fail the run halfway through a directory.
+func (h objectHeaders) validate() error {
+	if h.ACL != "" && !validACL(h.ACL) {
+		return fmt.Errorf("unknown ACL %q", h.ACL)
+	}
+	return validateMetadata(h.Metadata)
+}
+
+func validACL(acl string) bool {
+	for _, v := range types.ObjectCannedACL("").Values() {
+		if string(v) == acl {
+			return true
+		}
+	}
+	return false
+}
+
+// pathACLs maps directory names to the ACL of the objects below them, as
+// given to --acl-from-path.
+type pathACLs map[string]string
+
+// parsePathACLs parses dir=acl pairs separated by commas, e.g.
+// "public=public-read,private=private".
+func parsePathACLs(s string) (pathACLs, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	rules := pathACLs{}
+	for _, pair := range strings.Split(s, ",") {
+		dir, acl, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("invalid --acl-from-path rule %q, expected dir=acl", pair)
+		}
+		if !validACL(acl) {
+			return nil, fmt.Errorf("unknown ACL %q in --acl-from-path", acl)
+		}
+		rules[dir] = acl
+	}
+	return rules, nil
+}
+
+// acl returns the ACL of the deepest directory of key that has a rule, or ""
+// when none has.
+func (r pathACLs) acl(key string) string {
+	dirs := strings.Split(key, "/")
+	acl := ""
+	for _, dir := range dirs[:len(dirs)-1] {
+		if v, ok := r[dir]; ok {
+			acl = v
+		}
+	}
+	return acl
+}
+
+// maxMetadataSize is the limit of the user-defined metadata of one object,
+// counted as the sum of the bytes of all keys and values.
+const maxMetadataSize = 2048
+
+// validateMetadata makes sure every key is a valid HTTP header token and every
+// value is printable ASCII, since both are sent as x-amz-meta-* headers.
+func validateMetadata(metadata map[string]string) error {
+	size := 0
+	for k, v := range metadata {
+		if k == "" {
+			return fmt.Errorf("empty metadata key")
+		}
+		for _, c := range k {
+			if !isTokenChar(c) {
+				return fmt.Errorf("invalid character %q in metadata key %q", c, k)
+			}
+		}
+		for _, c := range v {
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("invalid character %q in metadata value of %q", c, k)
+			}
+		}
+		size += len(k) + len(v)
+	}
+	if size > maxMetadataSize {
+		return fmt.Errorf("metadata is %d bytes, the limit is %d", size, maxMetadataSize)
+	}
+	return nil
+}
+
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
+
+// cacheControlRule sets the Cache-Control of the objects matching pattern.
+type cacheControlRule struct {
+	pattern string
+	value   string
+}
+
+// parseCacheControl splits repeated --cache-control flags into a default
+// value and per-glob rules. A value is a rule when the part before the first
+// "=" looks like a file pattern, e.g. "*.html=no-cache"; Cache-Control
+// directives such as "max-age=3600" never contain '*', '?', '[' or '.'.
+func parseCacheControl(values []string) (string, []cacheControlRule, error) {
+	var (
+		plain string
+		rules []cacheControlRule
+	)
+	for _, v := range values {
+		pattern, value, ok := strings.Cut(v, "=")
+		if !ok || !strings.ContainsAny(pattern, "*?[.") {
+			plain = v
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return "", nil, fmt.Errorf("invalid cache-control pattern %q: %w", pattern, err)
+		}
+		rules = append(rules, cacheControlRule{pattern: pattern, value: value})
+	}
+	return plain, rules, nil
+}
+
+// mtimeMaxAge is one --cache-control-mtime-map entry: files younger than age
+// are cached for maxAge seconds. The catch-all "*" entry has age 0.
+type mtimeMaxAge struct {
+	age    time.Duration
+	maxAge int
+}
+
+// defaultMtimeMap is the --cache-control-mtime-map default.
+const defaultMtimeMap = "1h=300,1d=3600,7d=86400,*=2592000"
+
+// parseMtimeMap parses age=max-age pairs such as "1h=3600,1d=86400,*=2592000"
+// into entries sorted by age, with the "*" entry last. Ages are Go durations
+// or a number of days like "7d".
+func parseMtimeMap(s string) ([]mtimeMaxAge, error) {
+	var (
+		entries  []mtimeMaxAge
+		fallback *mtimeMaxAge
+	)
+	for _, pair := range strings.Split(s, ",") {
+		ageText, maxAgeText, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --cache-control-mtime-map entry %q, expected age=max-age", pair)
+		}
+		maxAge, err := strconv.Atoi(maxAgeText)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("invalid max-age %q in --cache-control-mtime-map", maxAgeText)
+		}
+
+		if ageText == "*" {
+			fallback = &mtimeMaxAge{maxAge: maxAge}
+			continue
+		}
+
+		var age time.Duration
+		if strings.HasSuffix(ageText, "d") {
+			n, err := strconv.Atoi(strings.TrimSuffix(ageText, "d"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid age %q in --cache-control-mtime-map", ageText)
+			}
+			age = time.Duration(n) * 24 * time.Hour
+		} else if age, err = time.ParseDuration(ageText); err != nil {
+			return nil, fmt.Errorf("invalid age %q in --cache-control-mtime-map", ageText)
+		}
+		entries = append(entries, mtimeMaxAge{age: age, maxAge: maxAge})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].age < entries[j].age })
+	if fallback != nil {
+		entries = append(entries, *fallback)
+	}
+	return entries, nil
+}
+
+// mtimeCacheControl returns the Cache-Control of a file last modified at
+// mtime, or "" when no entry covers its age.
+func mtimeCacheControl(entries []mtimeMaxAge, mtime time.Time) string {
+	age := time.Since(mtime)
+	for _, e := range entries {
+		if e.age == 0 || age < e.age {
+			return fmt.Sprintf("max-age=%d", e.maxAge)
+		}
+	}
+	return ""
+}
+
+// headerRules maps glob patterns to the headers of the objects they match.
+type headerRules map[string]objectHeaders
+
+// loadHeaderRules reads a JSON file such as
+//
+//	{"*.html": {"Cache-Control": "no-cache"}, "*.js": {"Cache-Control": "public, max-age=31536000"}}
+//
+// Every pattern may set Cache-Control, Content-Disposition, Content-Encoding,
+// ACL and Metadata.
+func loadHeaderRules(path string) (headerRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := headerRules{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for pattern, headers := range rules {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %w", pattern, path, err)
+		}
+		if err := headers.validate(); err != nil {
+			return nil, fmt.Errorf("invalid headers for %q in %s: %w", pattern, path, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// resolve applies every rule matching the file name or the object key on top
+// of base. Rules are applied in pattern order so the result is deterministic.
+func (r headerRules) resolve(base objectHeaders, name, key string) objectHeaders {
+	patterns := make([]string, 0, len(r))
+	for pattern := range r {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		matchName, _ := filepath.Match(pattern, name)
+		matchKey, _ := filepath.Match(pattern, key)
+		if matchName || matchKey {
+			base = base.merge(r[pattern])
+		}
+	}
+	return base
+}
+
+// metadataFromEnv adds the environment variables called names to metadata
+// under their lowercased name, without overriding keys already present.
+// Variables that are not set are skipped with a warning.
+func metadataFromEnv(names []string, metadata map[string]string) map[string]string {
+	for _, name := range names {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			log.Printf("Warning: %s is not set, it is not added to the metadata", name)
+			continue
+		}
+
+		key := strings.ToLower(name)
+		if _, ok := metadata[key]; ok {
+			continue
+		}
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// parseMetadataJSON reads the string fields of a JSON object such as
+//
+//	{"build": "v1.2.3", "deploy-env": "prod"}
+//
+// as object metadata. Fields of other types are skipped with a warning.
+func parseMetadataJSON(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(s), &fields); err != nil {
+		return nil, fmt.Errorf("invalid metadata JSON: %w", err)
+	}
+
+	metadata := make(map[string]string, len(fields))
+	for k, v := range fields {
+		value, ok := v.(string)
+		if !ok {
+			log.Printf("Warning: metadata %q is not a string, it is skipped", k)
+			continue
+		}
+		metadata[k] = value
+	}
+	return metadata, nil
+}
+
+// parseMetadata converts repeated key=value flags into an object metadata map.
+func parseMetadata(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	metadata := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid metadata %q, expected key=value", pair)
+		}
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+// signedRunOperations are the requests carrying the --signed-header-secret
+// headers: the PUTs of an upload and the completion of a multipart upload.
+var signedRunOperations = map[string]bool{
+	"PutObject":               true,
+	"UploadPart":              true,
+	"CompleteMultipartUpload": true,
+}
+
+// signedRunHeaders returns the --signed-header-secret option of the upload
+// requests of one run. It sends a random run ID, the Unix time of the start
+// of the run and X-CFR2-Run-Hash, the hex HMAC-SHA256 of the two under
+// secret, so the receiving endpoint can check where a request comes from.
+// Only the signedRunOperations get the headers.
+func signedRunHeaders(secret string) (func(*s3.Options), error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	runID := hex.EncodeToString(id)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(runID + timestamp))
+	sum := hex.EncodeToString(mac.Sum(nil))
+
+	return s3.WithAPIOptions(func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("SignedRunHeaders", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok && signedRunOperations[awsmiddleware.GetOperationName(ctx)] {
+				req.Header.Set("X-CFR2-Run-Id", runID)
+				req.Header.Set("X-CFR2-Run-Timestamp", timestamp)
+				req.Header.Set("X-CFR2-Run-Hash", sum)
+			}
+			return next.HandleBuild(ctx, in)
+		}), middleware.After)
+	}), nil
+}
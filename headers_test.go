@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []string
+		wantPlain string
+		wantRules []cacheControlRule
+		wantErr   bool
+	}{
+		{name: "none"},
+		{name: "directive", values: []string{"public, max-age=3600"}, wantPlain: "public, max-age=3600"},
+		{name: "no equals", values: []string{"no-store"}, wantPlain: "no-store"},
+		{name: "last directive wins", values: []string{"max-age=60", "max-age=120"}, wantPlain: "max-age=120"},
+		{
+			name:      "rules and a default",
+			values:    []string{"*.html=no-cache", "max-age=86400", "index.html=no-store", "assets/[ab]*=public, max-age=60"},
+			wantPlain: "max-age=86400",
+			wantRules: []cacheControlRule{
+				{pattern: "*.html", value: "no-cache"},
+				{pattern: "index.html", value: "no-store"},
+				{pattern: "assets/[ab]*", value: "public, max-age=60"},
+			},
+		},
+		{name: "invalid pattern", values: []string{"[.html=no-cache"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, rules, err := parseCacheControl(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCacheControl() error = %v, want error %v", err, tt.wantErr)
+			}
+			if plain != tt.wantPlain {
+				t.Errorf("parseCacheControl() default = %q, want %q", plain, tt.wantPlain)
+			}
+			if len(rules) != len(tt.wantRules) {
+				t.Fatalf("parseCacheControl() rules = %v, want %v", rules, tt.wantRules)
+			}
+			for i := range tt.wantRules {
+				if rules[i] != tt.wantRules[i] {
+					t.Errorf("rule %d = %v, want %v", i, rules[i], tt.wantRules[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSignedRunHeaders(t *testing.T) {
+	hashes := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+
+		mac := hmac.New(sha256.New, []byte("s3cr3t"))
+		mac.Write([]byte(r.Header.Get("X-CFR2-Run-Id") + r.Header.Get("X-CFR2-Run-Timestamp")))
+		hash := r.Header.Get("X-CFR2-Run-Hash")
+		if hash != "" && hash != hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("%s %s: X-CFR2-Run-Hash %s does not match the run ID and timestamp", r.Method, r.URL, hash)
+		}
+
+		op := r.Method
+		switch {
+		case r.URL.Query().Has("partNumber"):
+			op = "UploadPart"
+		case r.URL.Query().Has("uploadId"):
+			op = "CompleteMultipartUpload"
+			io.WriteString(w, "<CompleteMultipartUploadResult><ETag>\"etag\"</ETag></CompleteMultipartUploadResult>")
+		}
+		hashes[op] = hash
+	}))
+	defer srv.Close()
+
+	client := s3.New(s3.Options{
+		Region:           "auto",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		EndpointResolver: s3.EndpointResolverFromURL(srv.URL),
+		UsePathStyle:     true,
+		Retryer:          aws.NopRetryer{},
+	}, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+
+	option, err := signedRunHeaders("s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &uploader{runHeaders: option, putOptions: []func(*s3.Options){option}}
+	ctx := context.Background()
+
+	if _, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("bk"), Key: aws.String("a"), Body: strings.NewReader("a")}, u.putOptions...); err != nil {
+		t.Fatal(err)
+	}
+
+	part := &s3.UploadPartInput{Bucket: aws.String("bk"), Key: aws.String("a"), UploadId: aws.String("id"), PartNumber: 1, Body: strings.NewReader("a")}
+	partOptions, err := u.partOptions().Prepare(part, io.NewSectionReader(strings.NewReader("a"), 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.UploadPart(ctx, part, partOptions...); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bk"),
+		Key:             aws.String("a"),
+		UploadId:        aws.String("id"),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: []types.CompletedPart{{ETag: aws.String(`"etag"`), PartNumber: 1}}},
+	}, u.putOptions...); err != nil {
+		t.Fatal(err)
+	}
+
+	// other requests given the option stay unsigned
+	if _, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bk"), Key: aws.String("a")}, option); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []string{http.MethodPut, "UploadPart", "CompleteMultipartUpload"} {
+		if hashes[op] == "" {
+			t.Errorf("%s has no X-CFR2-Run-Hash", op)
+		}
+	}
+	if hash, ok := hashes[http.MethodHead]; !ok || hash != "" {
+		t.Errorf("HeadObject X-CFR2-Run-Hash = %q (sent %v), want none", hash, ok)
+	}
+}
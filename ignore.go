@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are read from every directory of an upload with
+// --respect-ignore-files.
+var ignoreFiles = []string{".gitignore", ".r2ignore"}
+
+// pathRule is one --include or --exclude pattern, or one line of an ignore
+// file.
+type pathRule struct {
+	pattern string
+	include bool
+	// dirOnly rules end with "/" in an ignore file and only match directories
+	dirOnly bool
+}
+
+// pathRules are applied in order, the last rule matching a path decides.
+type pathRules []pathRule
+
+// match returns whether the last rule matching the slash separated relative
+// path rel includes it, ok is false when no rule matches.
+func (r pathRules) match(rel string, isDir bool) (include, ok bool) {
+	for _, rule := range r {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchPattern(rule.pattern, rel) {
+			include, ok = rule.include, true
+		}
+	}
+	return include, ok
+}
+
+// ruleFlag adds the patterns of --include or --exclude to a shared list, so
+// the two flags keep the order they were given in.
+type ruleFlag struct {
+	rules   *pathRules
+	include bool
+}
+
+func (f ruleFlag) String() string {
+	return strings.Join(f.GetSlice(), ",")
+}
+
+func (f ruleFlag) Set(pattern string) error {
+	*f.rules = append(*f.rules, pathRule{pattern: strings.TrimSuffix(pattern, "/"), include: f.include})
+	return nil
+}
+
+func (f ruleFlag) Type() string {
+	return "pattern"
+}
+
+// GetSlice, Replace and Append make the patterns of one of the flags a list
+// in the config file. Its excludes are applied before its includes, the
+// order between the two flags is only kept on the command line.
+func (f ruleFlag) GetSlice() []string {
+	var patterns []string
+	for _, rule := range *f.rules {
+		if rule.include == f.include {
+			patterns = append(patterns, rule.pattern)
+		}
+	}
+	return patterns
+}
+
+func (f ruleFlag) Replace(patterns []string) error {
+	rules := (*f.rules)[:0:0]
+	for _, rule := range *f.rules {
+		if rule.include != f.include {
+			rules = append(rules, rule)
+		}
+	}
+	*f.rules = rules
+	for _, pattern := range patterns {
+		if err := f.Set(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f ruleFlag) Append(pattern string) error {
+	return f.Set(pattern)
+}
+
+// matchPattern reports whether the slash separated relative path rel matches
+// pattern. A pattern without a slash matches any element of rel, so "*.map"
+// and "node_modules" apply at any depth. Other patterns are matched from the
+// start of rel, with "**" standing for any number of elements. A pattern
+// matching a directory also matches everything below it.
+func matchPattern(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		for _, elem := range strings.Split(rel, "/") {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return matchElems(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(rel, "/"))
+}
+
+// matchElems matches the elements of a path against those of a pattern.
+// Elements left over once the pattern is used up are below a matching
+// directory.
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return true
+}
+
+// parseIgnoreFile parses the gitignore style content of an ignore file:
+// comments start with "#", "!" re-includes a path, a trailing "/" only
+// matches directories and a leading "/" anchors the pattern to the directory
+// of the file.
+func parseIgnoreFile(data string) pathRules {
+	var rules pathRules
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := pathRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.include = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// matchPattern anchors the patterns containing a slash
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// loadIgnoreFiles reads the ignore files of dir once, a file that cannot be
+// read is reported and left out.
+func (u *uploader) loadIgnoreFiles(dir string) {
+	if !u.respectIgnoreFiles {
+		return
+	}
+	if _, ok := u.ignores[dir]; ok {
+		return
+	}
+
+	var rules pathRules
+	for _, name := range ignoreFiles {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: ignoring %s: %s", filepath.Join(dir, name), err)
+			continue
+		}
+		rules = append(rules, parseIgnoreFile(string(data))...)
+	}
+	u.ignores[dir] = rules
+}
+
+// ignored reports whether the ignore files of the directories from u.root down
+// to the parent of path leave path out. Deeper files take precedence.
+func (u *uploader) ignored(path string, isDir bool) bool {
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == u.root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules := u.ignores[dirs[i]]
+		if len(rules) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		if include, ok := rules.match(filepath.ToSlash(rel), isDir); ok {
+			ignored = !include
+		}
+	}
+	return ignored
+}
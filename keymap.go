@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keyMap maps the absolute path of local files to the keys they are uploaded
+// to, overriding the key derived from the path.
+type keyMap map[string]string
+
+// loadKeyMap reads a --key-map-file, either a JSON object
+//
+//	{"local/path/file.ext": "remote/key"}
+//
+// or, for a .csv file, lines of local-path,remote-key. Local paths are
+// relative to the current directory. Mapped files that do not exist are
+// reported with a warning.
+func loadKeyMap(path string) (keyMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]string{}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		r := csv.NewReader(strings.NewReader(string(data)))
+		r.FieldsPerRecord = 2
+		r.Comment = '#'
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		for _, record := range records {
+			entries[record[0]] = record[1]
+		}
+	} else if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	m := make(keyMap, len(entries))
+	for local, key := range entries {
+		abs, err := filepath.Abs(local)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(abs); errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Warning: %s in %s does not exist", local, path)
+		}
+		m[abs] = strings.TrimLeft(key, "/")
+	}
+	return m, nil
+}
+
+// keyFor returns the key of the local file at path: its --key-map-file entry
+// if it has one, otherwise the derived key with the --key-regex-replace rules
+// applied.
+func (u *uploader) keyFor(path, derived string) string {
+	if u.keyMap != nil {
+		if abs, err := filepath.Abs(path); err == nil {
+			if key, ok := u.keyMap[abs]; ok {
+				return u.finalKey(key)
+			}
+		}
+	}
+	return u.finalKey(u.rewriteKey(derived))
+}
+
+// finalKey encodes the spaces and non-ASCII characters of key, adds the
+// --key-date-suffix and puts it into the --object-namespace.
+func (u *uploader) finalKey(key string) string {
+	switch u.spaceEncoding {
+	case "pct":
+		key = strings.ReplaceAll(key, " ", "%20")
+	case "plus":
+		key = strings.ReplaceAll(key, " ", "+")
+	}
+	if u.encodeUnicode {
+		key = encodeNonASCII(key)
+	}
+	return u.namespaced(u.dateSuffixed(key))
+}
+
+// encodeNonASCII percent-encodes every byte of key outside printable ASCII,
+// so "/" and the other ASCII characters keep their meaning.
+func encodeNonASCII(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c < 0x20 || c > 0x7e {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
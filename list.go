@@ -0,0 +1,521 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/cobra"
+)
+
+func listCmd() *cobra.Command {
+	list := &cobra.Command{
+		Use:     "list [remote-prefix]",
+		Aliases: []string{"ls"},
+		Short:   "list objects in the bucket",
+		Long:    "",
+		Args:    cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			long, _ := cmd.Flags().GetBool("long")
+			summary, _ := cmd.Flags().GetBool("summary")
+			rawBytes, _ := cmd.Flags().GetBool("bytes")
+			delimiter, _ := cmd.Flags().GetString("delimiter")
+			noDelimiter, _ := cmd.Flags().GetBool("no-delimiter")
+			maxPages, _ := cmd.Flags().GetInt("max-list-pages")
+			showTotals, _ := cmd.Flags().GetBool("list-show-size-totals")
+			allVersions, _ := cmd.Flags().GetBool("list-all-versions")
+			recursive, _ := cmd.Flags().GetBool("list-recursive")
+			output, _ := cmd.Flags().GetString("output")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			prefixFlag, _ := cmd.Flags().GetString("prefix")
+			flat, _ := cmd.Flags().GetBool("recursive")
+			startAfter, _ := cmd.Flags().GetString("start-after")
+			continuationToken, _ := cmd.Flags().GetString("continuation-token")
+			showRestore, _ := cmd.Flags().GetBool("show-restore-status")
+			concurrency, _ := cmd.Flags().GetInt("concurrency")
+			filterETag, _ := cmd.Flags().GetString("filter-etag")
+			filterSizeMin, _ := cmd.Flags().GetInt64("filter-size-min")
+			filterSizeMax, _ := cmd.Flags().GetInt64("filter-size-max")
+			modifiedAfter, _ := cmd.Flags().GetString("filter-modified-after")
+			modifiedBefore, _ := cmd.Flags().GetString("filter-modified-before")
+
+			filter := objectFilter{
+				etag:    strings.Trim(filterETag, `"`),
+				minSize: filterSizeMin,
+				maxSize: filterSizeMax,
+			}
+			for _, bound := range []struct {
+				flag  string
+				value string
+				t     *time.Time
+			}{
+				{"--filter-modified-after", modifiedAfter, &filter.modifiedAfter},
+				{"--filter-modified-before", modifiedBefore, &filter.modifiedBefore},
+			} {
+				if bound.value == "" {
+					continue
+				}
+				t, err := time.Parse(time.RFC3339, bound.value)
+				if err != nil {
+					log.Fatalf("invalid %s: %s", bound.flag, err)
+				}
+				*bound.t = t
+			}
+
+			if jsonOutput {
+				if cmd.Flags().Changed("output") && output != "json" {
+					log.Fatalln("--json cannot be combined with --output " + output)
+				}
+				output = "json"
+			}
+
+			switch {
+			case output != "text" && output != "json":
+				log.Fatalf("unknown output format %q", output)
+			case output == "json" && (summary || showTotals):
+				log.Fatalln("--output json cannot be combined with --summary or --list-show-size-totals")
+			case prefixFlag != "" && len(args) > 0:
+				log.Fatalln("give the prefix either as an argument or with --prefix")
+			case startAfter != "" && allVersions:
+				log.Fatalln("--start-after cannot be combined with --list-all-versions")
+			case showRestore && (allVersions || recursive || summary):
+				log.Fatalln("--show-restore-status cannot be combined with --list-all-versions, --list-recursive or --summary")
+			case concurrency < 1:
+				log.Fatalln("--concurrency must be at least 1")
+			case continuationToken != "" && (allVersions || recursive):
+				log.Fatalln("--continuation-token cannot be combined with --list-all-versions or --list-recursive")
+			case filter.active() && allVersions:
+				log.Fatalln("the --filter-* flags cannot be combined with --list-all-versions")
+			}
+
+			// the summary always covers everything below the prefix
+			if noDelimiter || flat || summary {
+				delimiter = ""
+			}
+
+			prefix := strings.TrimLeft(prefixFlag, "/")
+			if len(args) > 0 {
+				prefix = strings.TrimLeft(args[0], "/")
+			}
+
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			size := func(n int64) string {
+				if rawBytes {
+					return strconv.FormatInt(n, 10)
+				}
+				return formatBytes(n)
+			}
+
+			if allVersions {
+				versions, err := listVersions(ctx, client, prefix, maxPages)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				if err := printVersions(versions, output, size); err != nil {
+					log.Fatalln(err)
+				}
+				return
+			}
+
+			if recursive {
+				entries, err := listTree(ctx, client, prefix, startAfter, maxPages, filter)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				if err := printTree(entries, output, size); err != nil {
+					log.Fatalln(err)
+				}
+				return
+			}
+
+			var (
+				count    int
+				total    int64
+				prefixes []string
+				lastKey  string
+				listed   []listEntry
+			)
+
+			input := &s3.ListObjectsV2Input{
+				Bucket: aws.String(bucketName),
+				Prefix: aws.String(prefix),
+			}
+			if delimiter != "" {
+				input.Delimiter = aws.String(delimiter)
+			}
+			if startAfter != "" {
+				input.StartAfter = aws.String(startAfter)
+			}
+			if continuationToken != "" {
+				input.ContinuationToken = aws.String(continuationToken)
+			}
+
+			pages := 0
+			paginator := s3.NewListObjectsV2Paginator(client, input)
+			for paginator.HasMorePages() {
+				if maxPages > 0 && pages >= maxPages {
+					log.Printf("Stopped after %d pages (--max-list-pages), the listing is incomplete", pages)
+					if lastKey != "" {
+						log.Printf("Continue with --start-after %q", lastKey)
+					}
+					break
+				}
+				pages++
+
+				var page *s3.ListObjectsV2Output
+				err := withRetry(ctx, func() (err error) {
+					page, err = paginator.NextPage(ctx)
+					return err
+				})
+				if err != nil {
+					log.Fatalln(err)
+				}
+				if n := len(page.Contents); n > 0 {
+					lastKey = aws.ToString(page.Contents[n-1].Key)
+				}
+				// lets an interrupted listing continue from this page
+				if maxPages > 0 && page.NextContinuationToken != nil {
+					log.Printf("Continuation token: %s", aws.ToString(page.NextContinuationToken))
+				}
+
+				for _, p := range page.CommonPrefixes {
+					prefixes = append(prefixes, aws.ToString(p.Prefix))
+					if output == "json" {
+						listed = append(listed, listEntry{Key: aws.ToString(p.Prefix), Dir: true})
+					} else if !summary {
+						fmt.Printf("%19s %10s %s\n", "", "DIR", aws.ToString(p.Prefix))
+					}
+				}
+
+				var restore map[string]string
+				if showRestore {
+					restore = restoreStatuses(ctx, client, page.Contents, filter, concurrency)
+				}
+
+				for _, object := range page.Contents {
+					if !filter.match(object) {
+						continue
+					}
+
+					count++
+					total += object.Size
+
+					if summary {
+						continue
+					}
+
+					if output == "json" {
+						listed = append(listed, listEntry{
+							Key:          aws.ToString(object.Key),
+							Size:         object.Size,
+							LastModified: object.LastModified,
+							ETag:         strings.Trim(aws.ToString(object.ETag), `"`),
+							StorageClass: string(object.StorageClass),
+							Restore:      restore[aws.ToString(object.Key)],
+						})
+						continue
+					}
+
+					modified := aws.ToTime(object.LastModified).Local().Format("2006-01-02 15:04:05")
+					suffix := ""
+					if showRestore {
+						suffix = "  restore: " + restore[aws.ToString(object.Key)]
+					}
+					if long {
+						fmt.Printf("%s %10s %-34s %-10s %s%s\n", modified, size(object.Size), aws.ToString(object.ETag), object.StorageClass, aws.ToString(object.Key), suffix)
+					} else {
+						fmt.Printf("%s %10s %s%s\n", modified, size(object.Size), aws.ToString(object.Key), suffix)
+					}
+				}
+			}
+
+			if output == "json" {
+				if err := printEntries(listed); err != nil {
+					log.Fatalln(err)
+				}
+				return
+			}
+
+			if summary {
+				fmt.Printf("%d objects, %s\n", count, size(total))
+			}
+
+			if showTotals {
+				// the grouped prefixes were not walked, add them up separately
+				for _, p := range prefixes {
+					n, bytes, err := prefixTotals(ctx, client, p)
+					if err != nil {
+						log.Fatalln(err)
+					}
+					fmt.Printf("  %s: %d objects, %d bytes (%.2f MiB)\n", p, n, bytes, float64(bytes)/(1<<20))
+					count += n
+					total += bytes
+				}
+				fmt.Printf("Total: %d objects, %d bytes (%.2f MiB)\n", count, total, float64(total)/(1<<20))
+			}
+		},
+	}
+
+	list.Flags().BoolP("long", "l", false, "Also show the ETag and storage class of each object.")
+	list.Flags().Bool("summary", false, "Only print the number of objects and their total size.")
+	list.Flags().String("delimiter", "/", "Group keys sharing a prefix up to this delimiter into directory entries.")
+	list.Flags().Bool("no-delimiter", false, "List every object below the prefix without grouping.")
+	list.Flags().Int("max-list-pages", 0, "Stop after this many pages of up to 1000 keys, 0 means unlimited.")
+	list.Flags().Bool("list-show-size-totals", false, "Print the total number and size of the objects, with subtotals per prefix when grouping.")
+	list.Flags().Bool("list-all-versions", false, "List every version and delete marker of the objects instead of the latest versions, always flat.")
+	list.Flags().Bool("list-recursive", false, "List every object below the prefix as a tree indented by key depth.")
+	list.Flags().String("prefix", "", "Only list the keys starting with this prefix, the same as the remote-prefix argument.")
+	list.Flags().BoolP("recursive", "r", false, "List every object below the prefix without grouping, the same as --no-delimiter. --list-recursive shows them as a tree.")
+	list.Flags().StringP("output", "o", "text", "Format of the listing: text or json.")
+	list.Flags().Bool("json", false, "Print the listing as JSON for scripts, the same as --output json.")
+	list.Flags().String("continuation-token", "", "Continue a listing from the continuation token printed after each page when --max-list-pages is set.")
+	list.Flags().String("start-after", "", "Only list keys after this one, e.g. the last key printed by a run stopped by --max-list-pages, to list a large bucket in batches.")
+	list.Flags().String("filter-etag", "", "Only show objects whose ETag matches this value or glob pattern, e.g. to find objects with the same content.")
+	list.Flags().Int64("filter-size-min", -1, "Only show objects of at least this many bytes.")
+	list.Flags().Int64("filter-size-max", -1, "Only show objects of at most this many bytes, 0 finds the empty objects.")
+	list.Flags().String("filter-modified-after", "", "Only show objects modified after this RFC 3339 time, e.g. 2024-01-15T00:00:00Z.")
+	list.Flags().String("filter-modified-before", "", "Only show objects modified before this RFC 3339 time.")
+	list.Flags().Bool("show-restore-status", false, "Show the x-amz-restore status of each object, fetched with HeadObject.")
+	list.Flags().Int("concurrency", 8, "Number of HeadObject requests of --show-restore-status made at the same time.")
+	list.Flags().Bool("bytes", false, "Print sizes in bytes instead of human-readable units.")
+
+	return list
+}
+
+// listEntry is one object or grouped prefix of the plain listing in JSON.
+type listEntry struct {
+	Key          string     `json:"key"`
+	Dir          bool       `json:"dir,omitempty"`
+	Size         int64      `json:"size"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	StorageClass string     `json:"storageClass,omitempty"`
+	Restore      string     `json:"restore,omitempty"`
+}
+
+// printEntries writes the plain listing to stdout as JSON.
+func printEntries(entries []listEntry) error {
+	if entries == nil {
+		entries = []listEntry{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// printVersions writes the --list-all-versions listing to stdout.
+func printVersions(versions []objectVersion, output string, size func(int64) string) error {
+	if output == "json" {
+		if versions == nil {
+			versions = []objectVersion{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(versions)
+	}
+
+	for _, v := range versions {
+		modified := v.LastModified.Local().Format("2006-01-02 15:04:05")
+		length := size(v.Size)
+		if v.DeleteMarker {
+			length = "DELETED"
+		}
+		latest := ""
+		if v.IsLatest {
+			latest = "latest"
+		}
+		fmt.Printf("%s %10s %-32s %-6s %s\n", modified, length, v.VersionID, latest, v.Key)
+	}
+	return nil
+}
+
+// treeEntry is one object of list --list-recursive, depth is the number of
+// "/" in its key below the listed prefix.
+type treeEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	Depth        int       `json:"depth"`
+
+	rel string
+}
+
+// listTree lists every object below prefix, and after startAfter if set,
+// that passes filter, without a delimiter.
+func listTree(ctx context.Context, client *s3.Client, prefix, startAfter string, maxPages int, filter objectFilter) ([]treeEntry, error) {
+	var entries []treeEntry
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(startAfter)
+	}
+
+	pages := 0
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+	for paginator.HasMorePages() {
+		if maxPages > 0 && pages >= maxPages {
+			log.Printf("Stopped after %d pages (--max-list-pages), the listing is incomplete", pages)
+			break
+		}
+		pages++
+
+		var page *s3.ListObjectsV2Output
+		err := withRetry(ctx, func() (err error) {
+			page, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			if !filter.match(object) {
+				continue
+			}
+
+			key := aws.ToString(object.Key)
+			rel := strings.TrimPrefix(key, prefix)
+			entries = append(entries, treeEntry{
+				Key:          key,
+				Size:         object.Size,
+				LastModified: aws.ToTime(object.LastModified),
+				Depth:        strings.Count(rel, "/"),
+				rel:          rel,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// printTree writes the --list-recursive listing to stdout. In text form every
+// directory is printed once before its first object, and names are indented
+// two spaces per level.
+func printTree(entries []treeEntry, output string, size func(int64) string) error {
+	if output == "json" {
+		if entries == nil {
+			entries = []treeEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	var prev []string
+	for _, e := range entries {
+		parts := strings.Split(e.rel, "/")
+		dirs, name := parts[:len(parts)-1], parts[len(parts)-1]
+
+		// print the directories not shared with the previous key
+		common := 0
+		for common < len(dirs) && common < len(prev) && dirs[common] == prev[common] {
+			common++
+		}
+		for i := common; i < len(dirs); i++ {
+			fmt.Printf("%19s %10s %s%s/\n", "", "DIR", strings.Repeat("  ", i), dirs[i])
+		}
+		prev = dirs
+
+		if name == "" {
+			continue // folder marker
+		}
+		modified := e.LastModified.Local().Format("2006-01-02 15:04:05")
+		fmt.Printf("%s %10s %s%s\n", modified, size(e.Size), strings.Repeat("  ", e.Depth), name)
+	}
+	return nil
+}
+
+// prefixTotals counts the objects below prefix and adds up their size.
+func prefixTotals(ctx context.Context, client *s3.Client, prefix string) (int, int64, error) {
+	var (
+		count int
+		total int64
+	)
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		var page *s3.ListObjectsV2Output
+		err := withRetry(ctx, func() (err error) {
+			page, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+
+		for _, object := range page.Contents {
+			count++
+			total += object.Size
+		}
+	}
+	return count, total, nil
+}
+
+// restoreStatuses fetches the x-amz-restore header of the objects passing
+// filter with up to concurrency HeadObject requests at a time. Objects that
+// are not being restored get "-".
+func restoreStatuses(ctx context.Context, client *s3.Client, objects []types.Object, filter objectFilter, concurrency int) map[string]string {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		statuses = map[string]string{}
+		sem      = make(chan struct{}, concurrency)
+	)
+	for _, object := range objects {
+		if !filter.match(object) {
+			continue
+		}
+
+		key := aws.ToString(object.Key)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+
+			var out *s3.HeadObjectOutput
+			err := withRetry(ctx, func() (err error) {
+				out, err = client.HeadObject(ctx, &s3.HeadObjectInput{
+					Bucket:       aws.String(bucketName),
+					Key:          aws.String(key),
+					RequestPayer: requestPayer(),
+				})
+				return err
+			})
+
+			status := "-"
+			switch {
+			case err != nil:
+				log.Printf("Warning: %s: %s", key, err)
+				status = "unknown"
+			case aws.ToString(out.Restore) != "":
+				status = aws.ToString(out.Restore)
+			}
+
+			mu.Lock()
+			statuses[key] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/cobra"
+)
+
+func lockFileCmd() *cobra.Command {
+	lock := &cobra.Command{
+		Use:   "lock-file",
+		Short: "show and change the object lock retention of objects",
+		Long:  "",
+	}
+
+	lock.AddCommand(lockGetCmd())
+	lock.AddCommand(lockSetCmd())
+	lock.AddCommand(lockDescribePolicyCmd())
+
+	return lock
+}
+
+func lockGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <key>",
+		Short: "show the retention of an object",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			key := strings.TrimLeft(args[0], "/")
+
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var out *s3.GetObjectRetentionOutput
+			err = withRetry(ctx, func() (err error) {
+				out, err = client.GetObjectRetention(ctx, &s3.GetObjectRetentionInput{
+					Bucket:       aws.String(bucketName),
+					Key:          aws.String(key),
+					RequestPayer: requestPayer(),
+				})
+				return err
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			if out.Retention == nil || out.Retention.Mode == "" {
+				fmt.Printf("%s: no retention\n", key)
+				return
+			}
+			fmt.Printf("%s: %s until %s\n", key, out.Retention.Mode, aws.ToTime(out.Retention.RetainUntilDate).Format(time.RFC3339))
+		},
+	}
+}
+
+func lockSetCmd() *cobra.Command {
+	set := &cobra.Command{
+		Use:   "set <key>",
+		Short: "set the retention of an object",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			mode, _ := cmd.Flags().GetString("mode")
+			retainUntil, _ := cmd.Flags().GetString("retain-until")
+			bypassGovernance, _ := cmd.Flags().GetBool("bypass-governance")
+
+			key := strings.TrimLeft(args[0], "/")
+
+			retention := types.ObjectLockRetentionMode(strings.ToUpper(mode))
+			if retention != types.ObjectLockRetentionModeGovernance && retention != types.ObjectLockRetentionModeCompliance {
+				log.Fatalf("unknown retention mode %q, expected GOVERNANCE or COMPLIANCE", mode)
+			}
+
+			until, err := time.Parse(time.RFC3339, retainUntil)
+			if err != nil {
+				log.Fatalf("invalid --retain-until: %s", err)
+			}
+
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			err = withRetry(ctx, func() error {
+				_, err := client.PutObjectRetention(ctx, &s3.PutObjectRetentionInput{
+					Bucket: aws.String(bucketName),
+					Key:    aws.String(key),
+					Retention: &types.ObjectLockRetention{
+						Mode:            retention,
+						RetainUntilDate: aws.Time(until),
+					},
+					BypassGovernanceRetention: bypassGovernance,
+					RequestPayer:              requestPayer(),
+				})
+				return err
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+			log.Printf("%s is locked in %s mode until %s", key, retention, until.Format(time.RFC3339))
+		},
+	}
+
+	set.Flags().String("mode", "", "Retention mode: GOVERNANCE or COMPLIANCE.")
+	set.Flags().String("retain-until", "", "Keep the object until this RFC 3339 time, e.g. 2030-01-01T00:00:00Z.")
+	set.Flags().Bool("bypass-governance", false, "Allow shortening or removing a GOVERNANCE retention (x-amz-bypass-governance-retention).")
+	set.MarkFlagRequired("mode")
+	set.MarkFlagRequired("retain-until")
+
+	return set
+}
+
+func lockDescribePolicyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe-policy",
+		Short: "show the object lock configuration of the bucket",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var out *s3.GetObjectLockConfigurationOutput
+			err = withRetry(ctx, func() (err error) {
+				out, err = client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+					Bucket: aws.String(bucketName),
+				})
+				return err
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			config := out.ObjectLockConfiguration
+			if config == nil || config.ObjectLockEnabled == "" {
+				fmt.Printf("Object lock is not enabled for %s\n", bucketName)
+				return
+			}
+
+			fmt.Printf("Object lock: %s\n", config.ObjectLockEnabled)
+			if config.Rule == nil || config.Rule.DefaultRetention == nil {
+				fmt.Println("Default retention: none")
+				return
+			}
+
+			retention := config.Rule.DefaultRetention
+			period := fmt.Sprintf("%d days", retention.Days)
+			if retention.Years > 0 {
+				period = fmt.Sprintf("%d years", retention.Years)
+			}
+			fmt.Printf("Default retention: %s for %s\n", retention.Mode, period)
+		},
+	}
+}
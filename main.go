@@ -2,20 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"log"
 	"mime"
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/cuipeiyu/cloudflare-r2-uploader/r2"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -25,47 +27,111 @@ var (
 	accountId       = ""
 	accessKeyId     = ""
 	accessKeySecret = ""
-)
 
-type ProgressReader struct {
-	reader   io.Reader
-	total    int64
-	read     int64
-	progress func(int64, int64)
-}
+	debug = false
 
-func (pr *ProgressReader) Read(p []byte) (int, error) {
-	n, err := pr.reader.Read(p)
-	pr.read += int64(n)
-	pr.progress(pr.read, pr.total)
-	return n, err
-}
+	// dryRun makes the commands annotated with dryRunAnnotation only report
+	// what they would change
+	dryRun = false
+)
 
-func NewProgressReader(reader io.Reader, total int64, progress func(int64, int64)) *ProgressReader {
-	return &ProgressReader{
-		reader:   reader,
-		total:    total,
-		progress: progress,
+// dryRunAnnotation marks the commands that support --dry-run.
+const dryRunAnnotation = "dry-run"
+
+// debugf logs only when --debug is set.
+func debugf(format string, v ...any) {
+	if debug {
+		log.Printf("[debug] "+format, v...)
 	}
 }
 
 func main() {
-	viper.SetEnvPrefix("CFR2")
-	viper.AutomaticEnv()
+	var rootCmd = &cobra.Command{
+		Use: "cloudflare-r2-uploader",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			loadConfig()
+			if err := applyConfigFlags(cmd); err != nil {
+				log.Fatalln(err)
+			}
 
-	bucketName = viper.GetString("BUCKET")
-	accountId = viper.GetString("ACCOUNT_ID")
-	accessKeyId = viper.GetString("ACCESSKEY")
-	accessKeySecret = viper.GetString("SECRETKEY")
+			if virtualHost, _ := cmd.Flags().GetBool("s3-virtual-host"); virtualHost {
+				pathStyle = false
+			}
+
+			if printConfig {
+				printResolvedConfig(cmd)
+				os.Exit(0)
+			}
+
+			if generateConfig != "" {
+				if err := writeStarterConfig(generateConfig, cmd); err != nil {
+					log.Fatalln(err)
+				}
+				log.Printf("Starter config written to %s, fill in the %s values", generateConfig, configPlaceholder)
+				os.Exit(0)
+			}
+
+			if bucketName == "" || accountId == "" || accessKeyId == "" || accessKeySecret == "" {
+				log.Fatalln("unknown cloudflare config")
+			}
 
-	if bucketName == "" || accountId == "" || accessKeyId == "" || accessKeySecret == "" {
-		log.Fatalln("unknown cloudflare config")
-		return
+			// every command retries with these
+			if retryBaseDelay <= 0 || retryMaxDelay < retryBaseDelay {
+				log.Fatalln("--retry-base-delay must be positive and not longer than --retry-max-delay")
+			}
+
+			// a command that ignored it would change the bucket after all
+			if dryRun && cmd.Annotations[dryRunAnnotation] == "" {
+				log.Fatalf("%s does not support --dry-run", cmd.CommandPath())
+			}
+		},
 	}
 
-	var rootCmd = &cobra.Command{Use: "cloudflare-r2-uploader"}
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file (default is .cfr2.yaml in the current directory, then ~/.cfr2.yaml).")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Use the settings of this section below profiles: in the config file, defaults to CFR2_PROFILE. Environment variables still win.")
+	rootCmd.PersistentFlags().StringVar(&envFile, "env-file", "", "Load KEY=VALUE pairs from this .env file into the environment before reading the CFR2_* variables.")
+	rootCmd.PersistentFlags().BoolVar(&printConfig, "print-config", false, "Print the resolved configuration with the credentials redacted to stderr and exit.")
+	rootCmd.PersistentFlags().StringVar(&generateConfig, "generate-config", "", "Write a commented starter config file with the current settings and the flags of the command to this path and exit, credentials are left as placeholders.")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Print debug messages.")
+	rootCmd.PersistentFlags().StringVar(&manifestPublicKey, "public-key", "", "ECDSA public key in PEM, or a file holding it, that signed manifests read by verify-manifest, --require-manifest, --from-manifest or scrub must be signed with. Manifests that are not signed are then refused.")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Walk the files and objects and print what upload, sync, delete or scrub would change, without writing to the bucket.")
+	rootCmd.PersistentFlags().BoolVar(&regionAutoDetect, "region-auto-detect", false, "Look up the region of the bucket with GetBucketLocation instead of using \"auto\".")
+	rootCmd.PersistentFlags().BoolVar(&requesterPays, "request-payer", false, "Send x-amz-request-payer: requester, needed for requester-pays buckets.")
+	rootCmd.PersistentFlags().StringVar(&expectedBucketOwner, "expected-bucket-owner", "", "Account ID the bucket must belong to, sent as x-amz-expected-bucket-owner so requests to a bucket of another account fail.")
+	rootCmd.PersistentFlags().BoolVar(&pathStyle, "s3-path-style", pathStyle, "Address the bucket in the URL path instead of the host name, often needed for S3 compatible servers such as MinIO.")
+	rootCmd.PersistentFlags().Bool("s3-virtual-host", false, "Address the bucket in the host name (bucket.host), the default style of R2.")
+	rootCmd.MarkFlagsMutuallyExclusive("s3-path-style", "s3-virtual-host")
+	rootCmd.PersistentFlags().StringArrayVar(&requestHeaders, "upload-id-header", nil, "Extra HTTP header sent with every request as name=value, e.g. for tracing or billing IDs, can be repeated.")
+	rootCmd.PersistentFlags().StringArrayVar(&globalHeaders, "global-header", nil, "Extra HTTP header added to every request as name=value after it is signed, e.g. for routing by an S3 compatible endpoint, can be repeated. The header is not covered by the signature.")
+	rootCmd.PersistentFlags().DurationVar(&responseHeaderTimeout, "response-header-timeout", responseHeaderTimeout, "Fail a request when the response headers take longer than this after it was sent, 0 waits forever.")
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", maxRetries, "Number of retries of a failed request on 5xx, throttling or network errors.")
+	rootCmd.PersistentFlags().DurationVar(&retryBaseDelay, "retry-base-delay", retryBaseDelay, "Upper bound of the random delay before the first retry, doubled for every further retry.")
+	rootCmd.PersistentFlags().DurationVar(&retryMaxDelay, "retry-max-delay", retryMaxDelay, "Longest delay between two retries.")
+	rootCmd.PersistentFlags().BoolVar(&respectRetryAfter, "respect-retry-after", respectRetryAfter, "Wait at least as long as the Retry-After header of a throttled response asks before retrying.")
+	rootCmd.PersistentFlags().BoolVar(&failFastOnAuthError, "fail-fast-on-auth-error", failFastOnAuthError, "Abort the whole run with troubleshooting hints on the first 403 response.")
+	rootCmd.PersistentFlags().BoolVar(&skipOnAccessDenied, "skip-on-access-denied", false, "Warn about and skip objects that HeadObject or GetObject answer with 403, e.g. in partially accessible buckets. Disables --fail-fast-on-auth-error.")
+
+	// destructive operations
+	rootCmd.PersistentFlags().BoolVar(&confirmBeforeDelete, "confirm-before-delete", confirmBeforeDelete, "List the objects and ask before deleting them (default true on a terminal).")
+	rootCmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask for confirmation before deleting objects.")
 
 	rootCmd.AddCommand(uploadCmd())
+	rootCmd.AddCommand(syncCmd())
+	rootCmd.AddCommand(watchCmd())
+	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(downloadCmd())
+	rootCmd.AddCommand(presignCmd())
+	rootCmd.AddCommand(remoteCopyCmd(false))
+	rootCmd.AddCommand(remoteCopyCmd(true))
+	rootCmd.AddCommand(deleteCmd())
+	rootCmd.AddCommand(scrubCmd())
+	rootCmd.AddCommand(waitReplicatedCmd())
+	rootCmd.AddCommand(lockFileCmd())
+	rootCmd.AddCommand(getObjectAttributesCmd())
+	rootCmd.AddCommand(bucketCmd())
+	rootCmd.AddCommand(configureCmd())
+	rootCmd.AddCommand(verifyCmd())
+	rootCmd.AddCommand(verifyManifestCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -74,174 +140,814 @@ func main() {
 }
 
 func uploadCmd() *cobra.Command {
+	// --include and --exclude share the list to keep their order
+	var filters pathRules
+
 	upload := &cobra.Command{
 		Use:              "upload",
 		Short:            "upload",
 		Long:             "",
+		Annotations:      map[string]string{dryRunAnnotation: "true"},
 		TraverseChildren: true,
 		Args:             cobra.MinimumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			force, _ := cmd.Flags().GetBool("force")
+			hashCompareRemote, _ := cmd.Flags().GetBool("hash-compare-remote")
+			noClobber, _ := cmd.Flags().GetBool("no-clobber")
+			maxKeyLength, _ := cmd.Flags().GetInt("max-key-length")
+			bypassGovernance, _ := cmd.Flags().GetBool("object-lock-bypass-governance")
+			contentMD5, _ := cmd.Flags().GetBool("content-md5")
+			contentSHA256, _ := cmd.Flags().GetBool("upload-content-sha256")
+			checksumMetadata, _ := cmd.Flags().GetBool("checksum-metadata")
+			contentRangeValue, _ := cmd.Flags().GetString("content-range")
+			sourceArchive, _ := cmd.Flags().GetBool("upload-source-archive")
+			signedHeaderSecret, _ := cmd.Flags().GetString("signed-header-secret")
+			autoBucketCreate, _ := cmd.Flags().GetBool("auto-bucket-create")
+			putIfMatch, _ := cmd.Flags().GetString("put-if-match")
+			putIfNoneMatch, _ := cmd.Flags().GetString("put-if-none-match")
+			dedupByHash, _ := cmd.Flags().GetBool("dedup-by-hash")
+			dedupCopy, _ := cmd.Flags().GetBool("dedup-copy")
+			linkExisting, _ := cmd.Flags().GetBool("link-existing")
+			modifiedOnly, _ := cmd.Flags().GetBool("upload-modified-only")
+			stateFile, _ := cmd.Flags().GetString("state-file")
+			resume, _ := cmd.Flags().GetBool("resume")
+			gzipStdin, _ := cmd.Flags().GetBool("gzip")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			compact, _ := cmd.Flags().GetBool("compact-progress")
+			progressMode, _ := cmd.Flags().GetString("progress")
+			readBufferSize, _ := cmd.Flags().GetInt("read-buffer-size")
+			limitRate, _ := cmd.Flags().GetString("limit-rate")
+			multipartThreshold, _ := cmd.Flags().GetInt64("multipart-threshold")
+			noMultipartForText, _ := cmd.Flags().GetBool("no-multipart-for-text")
+			keepFailedMultipart := keepFailedMultipartFlag(cmd)
+			enableManager, _ := cmd.Flags().GetBool("enable-transfer-manager")
+			managerConcurrency, _ := cmd.Flags().GetInt("manager-concurrency")
+			managerPartSize, _ := cmd.Flags().GetInt64("manager-part-size")
+			partSize := partSizeFlag(cmd)
+			partConcurrency, _ := cmd.Flags().GetInt("part-concurrency")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			ignoreErrors, _ := cmd.Flags().GetBool("ignore-errors")
+			continueOnError, _ := cmd.Flags().GetBool("continue-on-error")
+			abortOnError, _ := cmd.Flags().GetBool("abort-on-first-error")
+			skipUnreadable, _ := cmd.Flags().GetBool("skip-if-source-unreadable")
+			skipDotFiles, _ := cmd.Flags().GetBool("skip-dot-files")
+			respectIgnoreFiles, _ := cmd.Flags().GetBool("respect-ignore-files")
+			skipUnsupported, _ := cmd.Flags().GetBool("skip-unsupported-files")
+			followSymlinks, _ := cmd.Flags().GetBool("follow-symlinks")
+			skipZeroByte, _ := cmd.Flags().GetBool("skip-zero-byte")
+			uploadInOrder, _ := cmd.Flags().GetBool("upload-in-order")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			purgeCache, _ := cmd.Flags().GetBool("auto-invalidate-cloudflare-cache")
+			zoneID, _ := cmd.Flags().GetString("cf-zone-id")
+			apiToken, _ := cmd.Flags().GetString("cf-api-token")
+			errorLog, _ := cmd.Flags().GetString("error-log")
+			maxErrors, _ := cmd.Flags().GetInt("max-error-count")
+			output, _ := cmd.Flags().GetString("output")
+			summaryFormat, _ := cmd.Flags().GetString("upload-summary-format")
+			cacheControlValues, _ := cmd.Flags().GetStringArray("cache-control")
+			contentDisposition, _ := cmd.Flags().GetString("content-disposition")
+			contentEncoding, _ := cmd.Flags().GetString("content-encoding")
+			acl, _ := cmd.Flags().GetString("acl")
+			contentType, _ := cmd.Flags().GetString("content-type")
+			charset, _ := cmd.Flags().GetString("content-type-charset")
+			metadataPairs, _ := cmd.Flags().GetStringArray("metadata")
+			metadataEnv, _ := cmd.Flags().GetStringSlice("upload-metadata-from-env")
+			metadataJSON, _ := cmd.Flags().GetString("metadata-json")
+			tagJSON, _ := cmd.Flags().GetString("tag-json")
+			metadataFromXattr, _ := cmd.Flags().GetBool("upload-metadata-from-xattr")
+			aclFromMode, _ := cmd.Flags().GetBool("upload-acl-from-file-mode")
+			generateSRI, _ := cmd.Flags().GetBool("generate-sri")
+			aclFromPath, _ := cmd.Flags().GetString("acl-from-path")
+			cacheControlFromMtime, _ := cmd.Flags().GetBool("cache-control-from-mtime")
+			mtimeMapText, _ := cmd.Flags().GetString("cache-control-mtime-map")
+			metadataMap, _ := cmd.Flags().GetString("metadata-map")
+			requireManifest, _ := cmd.Flags().GetString("require-manifest")
+			signedManifestPath, _ := cmd.Flags().GetString("signed-manifest")
+			signingKeyPath, _ := cmd.Flags().GetString("signing-key")
+			keyRegexReplace, _ := cmd.Flags().GetStringArray("key-regex-replace")
+			keyMapFile, _ := cmd.Flags().GetString("key-map-file")
+			namespace, _ := cmd.Flags().GetString("object-namespace")
+			namespaceSeparator, _ := cmd.Flags().GetString("namespace-separator")
+			spaceEncoding, _ := cmd.Flags().GetString("key-encode-spaces")
+			encodeUnicode, _ := cmd.Flags().GetBool("encode-key-unicode")
+			dateSuffix, _ := cmd.Flags().GetString("key-date-suffix")
+			dateSuffixPosition, _ := cmd.Flags().GetString("key-date-suffix-position")
+			deleteMissing, _ := cmd.Flags().GetBool("delete-missing-from-manifest")
+
+			if output != "text" && output != "json" {
+				log.Fatalf("unknown output format %q", output)
+			}
+
+			switch progressMode {
+			case "auto", "bar", "plain", "json":
+			default:
+				log.Fatalf("unknown progress mode %q, expected auto, bar, plain or json", progressMode)
+			}
+			if compact {
+				if progressMode != "auto" && progressMode != "bar" {
+					log.Fatalf("--compact-progress cannot be combined with --progress %s", progressMode)
+				}
+				progressMode = "bar"
+			}
+			if progressMode == "auto" {
+				progressMode = "plain"
+				if isTerminal(os.Stdout) {
+					progressMode = "bar"
+				}
+			}
+			// the events own stdout
+			if progressMode == "json" && (quiet || generateSRI) {
+				log.Fatalln("--progress json cannot be combined with --quiet or --generate-sri")
+			}
+
+			// aborting is the default, --ignore-errors and --continue-on-error opt out of it
+			if cmd.Flags().Changed("abort-on-first-error") && abortOnError && (ignoreErrors || continueOnError) {
+				log.Fatalln("--abort-on-first-error cannot be combined with --ignore-errors or --continue-on-error")
+			}
+			if !abortOnError && !continueOnError {
+				ignoreErrors = true
+			}
+
+			summaryTemplate, err := template.New("summary").Parse(summaryFormat)
+			if err != nil {
+				log.Fatalf("invalid --upload-summary-format: %s", err)
+			}
+
+			// --metadata wins over --metadata-json, which wins over the environment
+			metadata, err := parseMetadataJSON(metadataJSON)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			pairs, err := parseMetadata(metadataPairs)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			metadata = objectHeaders{Metadata: metadata}.merge(objectHeaders{Metadata: pairs}).Metadata
+			metadata = metadataFromEnv(metadataEnv, metadata)
+
+			cacheControl, cacheControlRules, err := parseCacheControl(cacheControlValues)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			headers := objectHeaders{
+				CacheControl:       cacheControl,
+				ContentDisposition: contentDisposition,
+				ContentEncoding:    contentEncoding,
+				ACL:                acl,
+				Metadata:           metadata,
+			}
+			if err := headers.validate(); err != nil {
+				log.Fatalln(err)
+			}
+			if contentType != "" {
+				if _, _, err := mime.ParseMediaType(contentType); err != nil {
+					log.Fatalf("invalid --content-type %q: %s", contentType, err)
+				}
+			}
+
+			if apiToken == "" {
+				apiToken = viper.GetString("CF_API_TOKEN")
+			}
+			if purgeCache && (zoneID == "" || apiToken == "") {
+				log.Fatalln("--auto-invalidate-cloudflare-cache needs --cf-zone-id and --cf-api-token (or CFR2_CF_API_TOKEN)")
+			}
+
+			if parallel < 1 {
+				log.Fatalln("--parallel must be at least 1")
+			}
+
+			if partSize < r2.MinPartSize {
+				log.Fatalf("--part-size must be at least %d bytes (--part-size-mb 5)", r2.MinPartSize)
+			}
+			if partConcurrency < 1 {
+				log.Fatalln("--part-concurrency must be at least 1")
+			}
+			if enableManager {
+				if managerPartSize < manager.MinUploadPartSize {
+					log.Fatalf("--manager-part-size must be at least %d bytes", manager.MinUploadPartSize)
+				}
+				if managerConcurrency < 1 {
+					log.Fatalln("--manager-concurrency must be at least 1")
+				}
+				// the manager sends the same headers with every request
+				// of a multipart upload and has no per-part checksums
+				if contentMD5 || contentSHA256 || putIfMatch != "" || putIfNoneMatch != "" || bypassGovernance || signedHeaderSecret != "" {
+					log.Fatalln("--enable-transfer-manager cannot be combined with --content-md5, --upload-content-sha256, --put-if-match, --put-if-none-match, --object-lock-bypass-governance or --signed-header-secret")
+				}
+			}
+
+			if aclFromMode && acl != "" {
+				log.Fatalln("--upload-acl-from-file-mode cannot be combined with --acl")
+			}
+			if aclFromPath != "" && (acl != "" || aclFromMode) {
+				log.Fatalln("--acl-from-path cannot be combined with --acl or --upload-acl-from-file-mode")
+			}
+			pathACLs, err := parsePathACLs(aclFromPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var mtimeMap []mtimeMaxAge
+			if cacheControlFromMtime {
+				mtimeMap, err = parseMtimeMap(mtimeMapText)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			if metadataFromXattr && !xattrSupported {
+				log.Fatalln("--upload-metadata-from-xattr is only supported on Linux and macOS")
+			}
+
+			tags, err := parseTagJSON(tagJSON)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var rules headerRules
+			if metadataMap != "" {
+				rules, err = loadHeaderRules(metadataMap)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			// a rerun of an interrupted or failed upload skips the files
+			// the state file already has
+			if resume {
+				modifiedOnly = true
+				if stateFile == "" {
+					stateFile = defaultStateFile
+				}
+			}
+
+			var state uploadState
+			if modifiedOnly {
+				if stateFile == "" {
+					log.Fatalln("--upload-modified-only needs --state-file")
+				}
+				state, err = loadUploadState(stateFile)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			keyRewrites, err := parseKeyRewrites(keyRegexReplace)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			var keys keyMap
+			if keyMapFile != "" {
+				keys, err = loadKeyMap(keyMapFile)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			switch spaceEncoding {
+			case "none", "pct", "plus":
+			default:
+				log.Fatalf("unknown space encoding %q", spaceEncoding)
+			}
+
+			if dateSuffixPosition != "before-ext" && dateSuffixPosition != "after-ext" {
+				log.Fatalf("unknown key date suffix position %q", dateSuffixPosition)
+			}
+
+			var limiter *rateLimiter
+			if limitRate != "" {
+				rate, err := parseRate(limitRate)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				limiter = newRateLimiter(rate)
+			}
+
+			if putIfMatch != "" && putIfNoneMatch != "" {
+				log.Fatalln("--put-if-match and --put-if-none-match cannot be combined")
+			}
+
+			var contentRange *byteRange
+			if contentRangeValue != "" {
+				if contentMD5 || contentSHA256 || checksumMetadata {
+					log.Fatalln("--content-range cannot be combined with --content-md5, --upload-content-sha256 or --checksum-metadata")
+				}
+				contentRange, err = parseByteRange(contentRangeValue)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			// the archive members bypass the checks of the files of a
+			// directory, so these would be silently ignored
+			if sourceArchive && (requireManifest != "" || noClobber || dedupByHash || modifiedOnly || signedManifestPath != "") {
+				log.Fatalln("--upload-source-archive cannot be combined with --require-manifest, --no-clobber, --dedup-by-hash, --upload-modified-only, --resume or --signed-manifest")
+			}
+
+			if deleteMissing && requireManifest == "" {
+				log.Fatalln("--delete-missing-from-manifest needs --require-manifest")
+			}
+
+			if (signedManifestPath == "") != (signingKeyPath == "") {
+				log.Fatalln("--signed-manifest and --signing-key must be given together")
+			}
+			var signingKey *ecdsa.PrivateKey
+			if signingKeyPath != "" {
+				signingKey, err = loadSigningKey(signingKeyPath)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			var required manifest
+			if requireManifest != "" {
+				required, err = loadManifest(requireManifest)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
 
 			localPath := args[0]
 			remotePath := strings.TrimLeft(args[1], "/")
 
-			r2Resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				return aws.Endpoint{
-					URL: fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountId),
-				}, nil
-			})
+			if gzipStdin && localPath != stdinPath {
+				log.Fatalln("--gzip only works when uploading standard input with -")
+			}
+			if localPath == stdinPath {
+				if remotePath == "" || strings.HasSuffix(remotePath, "/") {
+					log.Fatalln("uploading standard input needs the full key as the remote path")
+				}
+				if dryRun || contentRange != nil || sourceArchive || dedupByHash || modifiedOnly || checksumMetadata {
+					log.Fatalln("--dry-run, --content-range, --upload-source-archive, --dedup-by-hash, --upload-modified-only and --checksum-metadata need a local file")
+				}
+			}
 
-			cfg, err := config.LoadDefaultConfig(context.TODO(),
-				config.WithEndpointResolverWithOptions(r2Resolver),
-				config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, accessKeySecret, "")),
-			)
+			sigCtx, stop := signalContext()
+			defer stop()
+
+			ctx := sigCtx
+			if timeout > 0 {
+				var cancelFn context.CancelFunc
+				ctx, cancelFn = context.WithTimeout(sigCtx, timeout)
+				defer cancelFn()
+			}
+
+			// cancelled by the errors of stopsRun, which let the run end
+			// normally
+			ctx, cancelRun := context.WithCancel(ctx)
+			defer cancelRun()
+
+			client, err := newR2Client(ctx)
 			if err != nil {
 				log.Fatal(err)
 			}
 
-			ctx, cancelFn := context.WithTimeout(context.Background(), time.Hour)
-			defer cancelFn()
+			if autoBucketCreate && !dryRun {
+				if err := ensureBucket(ctx, client); err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			u := &uploader{
+				client:              client,
+				parallel:            parallel,
+				force:               force,
+				hashCompareRemote:   hashCompareRemote,
+				contentMD5:          contentMD5,
+				contentSHA256:       contentSHA256,
+				checksumMetadata:    checksumMetadata,
+				noClobber:           noClobber,
+				maxKeyLength:        maxKeyLength,
+				quiet:               quiet || output == "json",
+				dryRun:              dryRun,
+				headers:             headers,
+				tags:                tags,
+				xattrMetadata:       metadataFromXattr,
+				aclFromMode:         aclFromMode,
+				pathACLs:            pathACLs,
+				mtimeMap:            mtimeMap,
+				cacheControl:        cacheControlRules,
+				rules:               rules,
+				charset:             charset,
+				contentTypeOverride: contentType,
+				readBufferSize:      readBufferSize,
+				limiter:             limiter,
+				multipartThreshold:  multipartThreshold,
+				noMultipartForText:  noMultipartForText,
+				keepFailedMultipart: keepFailedMultipart,
+				partSize:            partSize,
+				partConcurrency:     partConcurrency,
+				contentRange:        contentRange,
+				ignoreErrors:        ignoreErrors,
+				continueOnError:     continueOnError,
+				skipUnreadable:      skipUnreadable,
+				skipDotFiles:        skipDotFiles,
+				filters:             filters,
+				respectIgnoreFiles:  respectIgnoreFiles,
+				ignores:             map[string]pathRules{},
+				skipUnsupported:     skipUnsupported,
+				skipZeroByte:        skipZeroByte,
+				manifest:            required,
+				signManifest:        signingKey != nil && !dryRun,
+				keyMap:              keys,
+				keyRewrites:         keyRewrites,
+				spaceEncoding:       spaceEncoding,
+				encodeUnicode:       encodeUnicode,
+				dateSuffixAfterExt:  dateSuffixPosition == "after-ext",
+				namespace:           namespace,
+				namespaceSeparator:  namespaceSeparator,
+				state:               state,
+				stateFile:           stateFile,
+				maxErrors:           maxErrors,
+			}
+
+			if generateSRI {
+				u.sri = map[string]string{}
+			}
+
+			if enableManager {
+				u.transferManager = newTransferManager(client, managerPartSize, managerConcurrency, keepFailedMultipart)
+			}
+
+			// one date for the whole run, even if it crosses midnight
+			if dateSuffix != "" {
+				u.dateSuffix = time.Now().Format(dateSuffix)
+			}
+
+			if dedupByHash {
+				u.dedup, err = loadETagIndex(ctx, client)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				if !u.quiet {
+					log.Printf("Indexed %d distinct objects for --dedup-by-hash", len(u.dedup))
+				}
+				u.dedupCopy = dedupCopy || linkExisting
+			}
+
+			if signedHeaderSecret != "" {
+				option, err := signedRunHeaders(signedHeaderSecret)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				u.runHeaders = option
+				u.putOptions = append(u.putOptions, option)
+			}
+
+			// preconditions, a mismatch fails with 412 Precondition Failed
+			if putIfMatch != "" {
+				u.putOptions = append(u.putOptions, s3.WithAPIOptions(smithyhttp.SetHeaderValue("If-Match", putIfMatch)))
+			}
+			if putIfNoneMatch != "" {
+				u.putOptions = append(u.putOptions, s3.WithAPIOptions(smithyhttp.SetHeaderValue("If-None-Match", putIfNoneMatch)))
+			}
 
-			client := s3.NewFromConfig(cfg)
+			if bypassGovernance {
+				log.Println("Warning: --object-lock-bypass-governance overwrites objects under GOVERNANCE retention and needs the s3:BypassGovernanceRetention permission")
+				u.putOptions = append(u.putOptions, s3.WithAPIOptions(
+					smithyhttp.SetHeaderValue("X-Amz-Bypass-Governance-Retention", "true"),
+				))
+			}
 
-			log.Printf("Upload \"%s\" to \"%s\"", localPath, remotePath)
+			if !u.quiet {
+				log.Printf("Upload \"%s\" to \"%s\"", localPath, remotePath)
+			}
+
+			if localPath == stdinPath {
+				start := time.Now()
+				if err := u.uploadStream(ctx, os.Stdin, u.finalKey(u.rewriteKey(remotePath)), gzipStdin); err != nil {
+					if sigCtx.Err() != nil {
+						exitInterrupted(client)
+					}
+					log.Fatalln(err)
+				}
+				if err := u.printSummary(summaryTemplate, time.Since(start)); err != nil {
+					log.Println(err)
+				}
+				log.Println("Upload complete.")
+				return
+			}
 
 			info, err := os.Stat(localPath)
 			if err != nil {
 				log.Fatalln(err)
 			}
 
-			if info.IsDir() {
-				count := 0
-				skipped := 0
+			if contentRange != nil && info.IsDir() {
+				log.Fatalln("--content-range only works with a single file")
+			}
 
-				localPathAbs, _ := filepath.Abs(localPath)
+			if sourceArchive && info.IsDir() {
+				log.Fatalln("--upload-source-archive needs a .zip or .tar.gz file")
+			}
+
+			u.root, _ = filepath.Abs(localPath)
+			if !info.IsDir() {
+				u.root = filepath.Dir(u.root)
+			}
 
-				filepath.Walk(localPathAbs, func(path string, info fs.FileInfo, err error) error {
+			if progressMode == "json" && !dryRun {
+				u.events = newProgressEvents()
+			}
+
+			if progressMode == "bar" && !u.quiet && !dryRun && !sourceArchive {
+				files, size := 1, info.Size()
+				if info.IsDir() {
+					files, size, err = scanDir(u.root, followSymlinks, func(path string, info fs.FileInfo) bool {
+						return u.excluded(path, info) || u.unsupported(info) || u.empty(info)
+					})
 					if err != nil {
 						log.Fatalln(err)
 					}
+				}
+				u.compact = newCompactProgress(files, size)
+			}
 
-					if info.IsDir() {
-						return nil // keep going
-					}
+			// uploadOne uploads one file and reports its failure or adds it
+			// to the --signed-manifest
+			uploadOne := func(path, key string) {
+				err := u.uploadFile(ctx, path, key)
+				switch {
+				case stopsRun(err):
+					u.stop(err, cancelRun)
+				case err != nil && sigCtx.Err() == nil && !errors.Is(err, context.Canceled):
+					u.handleError(path, key, err)
+				case err == nil:
+					u.recordManifest(path)
+				}
+			}
 
-					key := strings.TrimPrefix(path, localPathAbs)
-					key = strings.TrimPrefix(filepath.Join(remotePath, key), "/")
+			start := time.Now()
 
-					skip := !force
-
-					if !force {
-						_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
-							Bucket: aws.String(bucketName),
-							Key:    aws.String(key),
-						})
-						if err != nil {
-							if strings.Contains(err.Error(), "Not Found") {
-								skip = false
-							}
-						}
-					}
+			if sourceArchive {
+				if err := u.uploadArchive(ctx, localPath, remotePath); err != nil {
+					log.Fatalln(err)
+				}
+			} else if info.IsDir() {
+				localPathAbs, _ := filepath.Abs(localPath)
 
-					if skip {
-						log.Printf("\"%s\" is exists will be skipped", key)
+				// HTML files held back by --upload-in-order
+				var pages [][2]string
 
-						skipped++
-					} else {
-						mimeType := mime.TypeByExtension(filepath.Ext(path))
+				pool := newUploadPool(u.parallel, uploadOne)
+				walkFiles(localPathAbs, followSymlinks, u.skipSpecial, func(path string, info fs.FileInfo, err error) error {
+					if ctx.Err() != nil {
+						return ctx.Err() // stop walking
+					}
 
-						log.Printf("Uploading [% 4d] %s as %s", count, key, mimeType)
+					if err != nil {
+						u.handleError(path, "", err)
+						return nil
+					}
 
-						file, err := os.Open(path)
-						if err != nil {
-							log.Fatalln(err)
+					if u.excluded(path, info) {
+						if info.IsDir() {
+							return filepath.SkipDir
 						}
-						defer file.Close()
+						return nil
+					}
 
-						fileInfo, err := file.Stat()
-						if err != nil {
-							panic(err)
-						}
+					if info.IsDir() {
+						return nil // keep going
+					}
 
-						progressReader := NewProgressReader(file, fileInfo.Size(), func(read, total int64) {
-							fmt.Printf("\rUploaded %d out of %d bytes (%.2f%%)", read, total, 100*float64(read)/float64(total))
-						})
-
-						_, err = client.PutObject(ctx, &s3.PutObjectInput{
-							Bucket:        aws.String(bucketName),
-							Key:           aws.String(key),
-							Body:          progressReader,
-							ContentType:   aws.String(mimeType),
-							ContentLength: fileInfo.Size(),
-						})
-						if err != nil {
-							log.Fatalln(err)
-						}
+					if u.unsupported(info) {
+						u.skipSpecial(path, "it is not a regular file ("+info.Mode().String()+")")
+						return nil
+					}
 
-						count++
+					if u.empty(info) {
+						debugf("Skipping empty file %s", path)
+						u.skippedEmpty++
+						return nil
 					}
 
+					key := strings.TrimPrefix(path, localPathAbs)
+					key = strings.TrimPrefix(filepath.Join(remotePath, key), "/")
+					key = u.keyFor(path, key)
+
+					if uploadInOrder && isHTML(path) {
+						pages = append(pages, [2]string{path, key})
+						return nil
+					}
+
+					pool.add(path, key)
 					return nil
 				})
+				pool.wait()
 
-				log.Printf("\nUploaded %d files, skipped %d files", count, skipped)
+				// the pages go live once the assets they reference are there
+				pool = newUploadPool(u.parallel, uploadOne)
+				for _, page := range pages {
+					if ctx.Err() != nil {
+						break
+					}
+					pool.add(page[0], page[1])
+				}
+				pool.wait()
+			} else if u.empty(info) {
+				debugf("Skipping empty file %s", localPath)
+				u.skippedEmpty++
 			} else {
-				key := remotePath
+				uploadOne(localPath, u.keyFor(localPath, remotePath))
+			}
 
-				skip := !force
+			// also keep the progress of an interrupted run
+			if state != nil && !dryRun {
+				if err := state.save(stateFile); err != nil {
+					log.Println(err)
+				}
+			}
 
-				if !force {
-					_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
-						Bucket: aws.String(bucketName),
-						Key:    aws.String(key),
-					})
-					if err != nil {
-						if strings.Contains(err.Error(), "Not Found") {
-							skip = false
-						}
-					}
+			if sigCtx.Err() != nil {
+				if u.compact != nil {
+					fmt.Println()
 				}
+				exitInterrupted(client)
+			}
 
-				if skip {
-					log.Printf("\"%s\" is exists will be skipped", key)
-				} else {
-					mimeType := mime.TypeByExtension(filepath.Ext(localPath))
+			if u.compact != nil {
+				u.compact.finish()
+			}
 
-					file, err := os.Open(localPath)
-					if err != nil {
-						log.Fatalln(err)
-					}
-					defer file.Close()
+			if u.signManifest {
+				if err := writeSignedManifest(signedManifestPath, signingKey, u.signed); err != nil {
+					log.Fatalln(err)
+				}
+				u.logf("Signed manifest of %d files written to %s", len(u.signed), signedManifestPath)
+			}
 
-					fileInfo, err := file.Stat()
-					if err != nil {
-						panic(err)
-					}
+			// the uploads a stopped run cancelled abort with their context,
+			// the ones that could not are still open
+			if u.stopped != nil {
+				abortMultipartUploads(client, openMultipartUploads())
+			}
 
-					progressReader := NewProgressReader(file, fileInfo.Size(), func(read, total int64) {
-						fmt.Printf("\rUploaded %d out of %d bytes (%.2f%%)", read, total, 100*float64(read)/float64(total))
-					})
+			// only prune a bucket that received every file
+			if deleteMissing && u.failed == 0 && u.stopped == nil {
+				if err := u.deleteMissing(ctx, remotePath); err != nil {
+					log.Fatalln(err)
+				}
+			}
 
-					_, err = client.PutObject(ctx, &s3.PutObjectInput{
-						Bucket:        aws.String(bucketName),
-						Key:           aws.String(key),
-						Body:          progressReader,
-						ContentType:   aws.String(mimeType),
-						ContentLength: fileInfo.Size(),
-					})
-					if err != nil {
-						log.Fatalln(err)
-					}
+			if purgeCache && !dryRun && u.uploaded > 0 {
+				if err := purgeEverything(ctx, zoneID, apiToken); err != nil {
+					log.Fatalln(err)
 				}
+				u.logf("Purged the cache of zone %s", zoneID)
 			}
 
-			log.Println("\nUpload complete.")
+			if dryRun {
+				if err := u.printPlan(output); err != nil {
+					log.Fatalln(err)
+				}
+				if u.stopped != nil {
+					log.Fatalln(u.stopped)
+				}
+				return
+			}
+
+			if errorLog != "" {
+				if err := u.writeErrorLog(errorLog); err != nil {
+					log.Println(err)
+				}
+			}
+
+			if err := u.printSummary(summaryTemplate, time.Since(start)); err != nil {
+				log.Println(err)
+			}
+
+			if len(u.unreadable) > 0 {
+				u.printUnreadable()
+			}
+			if len(u.skippedSpecial) > 0 {
+				u.printSkippedSpecial()
+			}
+
+			if u.failed > 0 && continueOnError {
+				u.printFailures()
+				os.Exit(1)
+			}
+			if u.stopped != nil {
+				log.Fatalln(u.stopped)
+			}
+			log.Println("Upload complete.")
 		},
 	}
 
 	// force upload
 	upload.Flags().Bool("force", true, "Force upload even if the file exists.")
+	upload.Flags().Bool("hash-compare-remote", false, "Download existing objects and only upload files whose SHA-256 differs.")
+	upload.Flags().String("put-if-match", "", "Only overwrite objects whose current ETag is this value (If-Match).")
+	upload.Flags().String("put-if-none-match", "", "Only write objects that do not match this ETag, '*' to only create new objects (If-None-Match).")
+	upload.Flags().Bool("no-clobber", false, "Stop at the first object that already exists instead of overwriting or skipping it: the uploads in flight are cancelled and the run exits with an error after its summary.")
+	upload.Flags().Bool("upload-modified-only", false, "Only upload files whose mtime or size changed since the last run recorded in --state-file.")
+	upload.Flags().String("state-file", "", "JSON file with the mtime, size and ETag of every uploaded file, used by --upload-modified-only. It is saved while uploading, so a run that fails or is killed keeps its progress.")
+	upload.Flags().Bool("resume", false, "Continue a previous run: the same as --upload-modified-only with --state-file defaulting to "+defaultStateFile+" in the current directory.")
+	upload.Flags().Bool("dedup-by-hash", false, "Skip files whose MD5 matches the ETag of any object in the bucket.")
+	upload.Flags().Bool("dedup-copy", false, "With --dedup-by-hash, copy the matching object to the target key on the server instead of skipping it.")
+	upload.Flags().Bool("link-existing", false, "Same as --dedup-copy: create the target key with CopyObject from the object with the same content, without transferring the data.")
+
+	// object keys
+	upload.Flags().Int("max-key-length", 1024, "Fail before uploading a file whose key is longer than this many bytes, 0 disables the check.")
+	upload.Flags().String("key-map-file", "", "JSON object or CSV file mapping local paths to the keys they are uploaded to, other files keep their derived key.")
+	upload.Flags().StringArray("key-regex-replace", nil, "Rewrite the object keys with pattern=replacement (Go regexp, $1 for groups), can be repeated and is applied in order.")
+	upload.Flags().String("key-encode-spaces", "none", "Encode spaces in keys: none, pct for %20 or plus for +.")
+	upload.Flags().Bool("encode-key-unicode", false, "Percent-encode every byte of the keys outside printable ASCII, e.g. for CDNs that mishandle UTF-8.")
+	upload.Flags().String("key-date-suffix", "", "Append the current date in this Go time format to every key, e.g. 20060102 turns index.html into index-20240115.html.")
+	upload.Flags().String("key-date-suffix-position", "before-ext", "Where --key-date-suffix goes: before-ext or after-ext.")
+	upload.Flags().String("object-namespace", "", "Namespace prepended to every object key after all other key changes, for shared buckets.")
+	upload.Flags().String("namespace-separator", "/", "Separator between --object-namespace and the key.")
+
+	// bucket setup
+	upload.Flags().Bool("auto-bucket-create", false, "Create the bucket if it does not exist yet, after asking when --confirm-before-delete is active.")
+
+	// deploy guards
+	upload.Flags().String("require-manifest", "", "JSON manifest of the expected files and their SHA-256, refuse to upload anything else.")
+	upload.Flags().String("signed-manifest", "", "Write a manifest of the uploaded files, signed with --signing-key, to this path. Check it with verify-manifest, --require-manifest verifies it too.")
+	upload.Flags().String("signing-key", "", "ECDSA P-256 private key in PEM used to sign --signed-manifest.")
+	upload.Flags().Bool("delete-missing-from-manifest", false, "After uploading, delete the objects below the remote path that --require-manifest does not list.")
+
+	// archives
+	upload.Flags().Bool("upload-source-archive", false, "Upload the files inside a local .zip or .tar.gz as separate objects below the remote path, without extracting it. The checks of --require-manifest, --no-clobber, --dedup-by-hash and --upload-modified-only do not apply to the members, so they cannot be combined.")
+
+	// file selection
+	upload.Flags().Bool("auto-invalidate-cloudflare-cache", false, "After uploading, purge everything from the Cloudflare cache of --cf-zone-id.")
+	upload.Flags().String("cf-zone-id", "", "ID of the Cloudflare zone serving the bucket, for --auto-invalidate-cloudflare-cache.")
+	upload.Flags().String("cf-api-token", "", "Cloudflare API token with the Cache Purge permission, defaults to CFR2_CF_API_TOKEN.")
+	upload.Flags().Int("parallel", 4, "Number of files of a directory uploaded at the same time. Per-file progress is only shown with 1, parallel uploads print a line when each file starts and finishes.")
+	upload.Flags().Bool("upload-in-order", false, "Upload the .html and .htm files of a directory after every other file, so pages never reference assets that are not there yet.")
+	upload.Flags().Bool("skip-zero-byte", false, "Skip empty files, e.g. lock files or directory markers, and count them separately in the summary.")
+	upload.Flags().Bool("skip-unsupported-files", true, "Skip device files, sockets and pipes with a warning instead of trying to upload them.")
+	upload.Flags().Bool("follow-symlinks", false, "Upload the targets of symlinks in a directory and walk symlinked directories, instead of skipping symlinks with a warning. Links back to a directory being uploaded are skipped.")
+	upload.Flags().Bool("skip-dot-files", false, "Skip files and directories whose name starts with a dot, e.g. .git, .env or .DS_Store.")
+	upload.Flags().Var(ruleFlag{rules: &filters}, "exclude", "Skip the files of a directory matching this glob, can be repeated. A pattern without \"/\" matches any path element, e.g. .DS_Store, node_modules or *.map, others match the path from the directory with ** for any depth. --include and --exclude apply in order, the last match wins.")
+	upload.Flags().Var(ruleFlag{rules: &filters, include: true}, "include", "Upload the files matching this glob even if an earlier --exclude matched them, can be repeated.")
+	upload.Flags().Bool("respect-ignore-files", false, "Skip the files and directories listed in the .gitignore and .r2ignore files of the uploaded directory and its subdirectories. .r2ignore files are not uploaded.")
+
+	// error handling
+	upload.Flags().Bool("abort-on-first-error", true, "Abort the run on the first failed file, --abort-on-first-error=false is the same as --ignore-errors.")
+	upload.Flags().Bool("ignore-errors", false, "Log failed files and keep going instead of aborting the run.")
+	upload.Flags().Bool("continue-on-error", false, "Keep going when a file fails, report all failures at the end and exit non-zero.")
+	upload.Flags().Bool("skip-if-source-unreadable", false, "Warn about local files that cannot be read and skip them instead of failing.")
+	upload.Flags().Int("max-error-count", 0, "Abort after this many errors even with --ignore-errors, 0 means unlimited.")
+	upload.Flags().String("error-log", "", "Write the failed files to this file as JSON.")
+
+	// dry run
+	upload.Flags().StringP("output", "o", "text", "Format of the --dry-run report: text or json.")
+
+	// local reads
+	upload.Flags().String("content-range", "", "Only upload the bytes start-end/total of a single file, e.g. to resume a failed upload by hand.")
+	upload.Flags().Bool("gzip", false, "Gzip standard input, uploaded with - as the local path, on the fly. Keys not ending in .gz get Content-Encoding: gzip.")
+	upload.Flags().Int("read-buffer-size", 256<<10, "Size in bytes of the buffer local files are read through, 0 to read them directly.")
+
+	// large files
+	upload.Flags().Int64("multipart-threshold", 100<<20, "Upload files of at least this many bytes as multipart uploads, 0 always uses a single PUT.")
+	upload.Flags().Bool("no-multipart-for-text", false, "Upload files with a text/* content type with a single PUT up to the 5 GiB limit, whatever their size, so their ETag is the MD5 of their content.")
+	upload.Flags().Int64("part-size", 16<<20, "Size in bytes of the parts of a multipart upload, at least 5 MiB. It is raised for files that would need more than 10000 parts.")
+	upload.Flags().Int64("part-size-mb", 16, "--part-size in MiB, e.g. 100 for 104857600 bytes.")
+	upload.MarkFlagsMutuallyExclusive("part-size", "part-size-mb")
+	upload.Flags().Bool("abort-multipart-on-error", true, "Abort a multipart upload whose parts could not be sent, so they do not take up storage.")
+	upload.Flags().Bool("no-abort-multipart-on-error", false, "Keep the parts of a failed multipart upload in the bucket for inspection, its upload ID is printed. Interrupted uploads are still aborted.")
+	upload.MarkFlagsMutuallyExclusive("abort-multipart-on-error", "no-abort-multipart-on-error")
+	upload.Flags().Int("part-concurrency", 4, "Number of parts of one file uploaded at the same time, on top of --parallel.")
+	upload.Flags().Bool("enable-transfer-manager", false, "Send the files with the upload manager of the AWS SDK, which picks a single PUT or a multipart upload by --manager-part-size, instead of --multipart-threshold, --part-size and --part-concurrency. Standard input and archives are not sent with it.")
+	upload.Flags().Int("manager-concurrency", manager.DefaultUploadConcurrency, "Number of parts of one file the upload manager sends at the same time, on top of --parallel.")
+	upload.Flags().Int64("manager-part-size", manager.DefaultUploadPartSize, "Size in bytes of the parts of the upload manager, at least 5 MiB. Smaller files are sent with a single PUT.")
+	upload.Flags().String("limit-rate", "", "Limit the total upload speed of all files and parts to this many bytes per second, e.g. 5MB or 500K.")
+	upload.Flags().Duration("timeout", 0, "Abort the whole run after this long, 0 never times out.")
+
+	// progress output
+	upload.Flags().String("upload-summary-format", defaultSummaryFormat, "Go template of the final summary with .Uploaded, .Skipped, .Empty, .Failed, .Bytes, .Duration and .Speed (bytes per second).")
+	upload.Flags().BoolP("quiet", "q", false, "Suppress per-file progress and only print the final summary.")
+	upload.Flags().Bool("compact-progress", false, "Show a single summary line of files, bytes, speed and ETA instead of per-file progress, the same as --progress bar.")
+	upload.Flags().String("progress", "auto", "Progress output: bar for a progress bar of the whole run and the current file, plain for throttled per-file log lines, json for line-delimited JSON events on stdout. auto picks bar on a terminal and plain otherwise.")
+
+	// object headers
+	upload.Flags().StringArray("cache-control", nil, "Cache-Control header of the uploaded objects, or glob=value to only apply to matching files, can be repeated.")
+	upload.Flags().String("content-disposition", "", "Content-Disposition header of the uploaded objects.")
+	upload.Flags().String("content-encoding", "", "Content-Encoding header of the uploaded objects, e.g. gzip or br for files that are already compressed.")
+	upload.Flags().String("content-type", "", "Content-Type of every uploaded object, instead of guessing it from the extension or the content.")
+	upload.Flags().String("content-type-charset", "", "Charset appended to text/* content types, e.g. utf-8.")
+	upload.Flags().Bool("content-md5", false, "Compute the MD5 of each file before uploading and send it as Content-MD5.")
+	upload.Flags().Bool("checksum-metadata", false, "Store the SHA-256 of each file as the metadata x-amz-meta-sha256, which verify checks the local files against.")
+	upload.Flags().Bool("upload-content-sha256", false, "Compute the SHA-256 of each file before uploading and sign the payload with it (x-amz-content-sha256) instead of UNSIGNED-PAYLOAD.")
+	upload.Flags().String("signed-header-secret", "", "Sign every PUT, part and multipart completion with X-CFR2-Run-Hash, the HMAC-SHA256 of the X-CFR2-Run-Id and X-CFR2-Run-Timestamp headers under this secret.")
+	upload.Flags().Bool("object-lock-bypass-governance", false, "Overwrite objects locked in GOVERNANCE mode (x-amz-bypass-governance-retention).")
+	upload.Flags().String("acl", "", "Canned ACL of the uploaded objects, e.g. public-read.")
+	upload.Flags().Bool("generate-sri", false, "Print \"<key> sha384-<base64>\" Subresource Integrity values of the uploaded files to stdout, and add them to --signed-manifest.")
+	upload.Flags().Bool("cache-control-from-mtime", false, "Set Cache-Control: max-age from the age of each file, see --cache-control-mtime-map. --cache-control wins.")
+	upload.Flags().String("cache-control-mtime-map", defaultMtimeMap, "age=max-age pairs for --cache-control-from-mtime: files younger than the first age get its max-age, and so on, \"*\" covers the rest. Ages are durations or days like 7d.")
+	upload.Flags().String("acl-from-path", "", "ACLs by directory name in the key as dir=acl pairs, e.g. \"public=public-read,private=private\". The deepest matching directory wins.")
+	upload.Flags().Bool("upload-acl-from-file-mode", false, "Upload world-readable files (o+r) as public-read and all others as private.")
+	upload.Flags().StringArray("metadata", nil, "Custom object metadata as key=value, can be repeated.")
+	upload.Flags().String("metadata-json", "", "Custom object metadata as a JSON object of strings, --metadata wins on conflicts.")
+	upload.Flags().StringSlice("upload-metadata-from-env", nil, "Comma separated environment variables added as metadata under their lowercased name, e.g. CI_COMMIT_SHA,CI_PIPELINE_ID.")
+	upload.Flags().Bool("upload-metadata-from-xattr", false, "Add the user.* extended attributes of each file as metadata, without the user. prefix (Linux and macOS).")
+	upload.Flags().String("tag-json", "", "Object tags as a JSON object of strings, set with PutObjectTagging after each upload.")
+	upload.Flags().String("metadata-map", "", "JSON file mapping glob patterns to the headers of matching objects.")
 
 	return upload
 }
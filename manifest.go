@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// manifestEntry is one file of a --require-manifest file:
+//
+//	[{"path": "index.html", "sha256": "9f86d08..."}, {"path": "js/app.js"}]
+//
+// Paths are relative to the uploaded directory and use forward slashes. The
+// hash is optional, without it only the presence of the file is checked.
+type manifestEntry struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+	// SRI is the --generate-sri value of a --signed-manifest entry
+	SRI string `json:"sri,omitempty"`
+}
+
+// manifest maps the relative path of every expected file to its SHA-256.
+type manifest map[string]string
+
+func loadManifest(path string) (manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// a --signed-manifest is only used once its signature checks out
+	// against the --public-key, which in turn only accepts signed ones
+	if isSignedManifest(data) {
+		key, err := trustedPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = verifySignedManifest(data, key); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if manifestPublicKey != "" {
+		return nil, fmt.Errorf("%s is not signed, --public-key only accepts signed manifests", path)
+	}
+
+	var entries []manifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	m := make(manifest, len(entries))
+	for _, entry := range entries {
+		m[strings.TrimPrefix(entry.Path, "./")] = strings.ToLower(entry.SHA256)
+	}
+	return m, nil
+}
+
+// verify returns an error unless the file at path is listed as rel and, when
+// the manifest has a hash for it, its content matches.
+func (m manifest) verify(rel, path string) error {
+	want, ok := m[rel]
+	if !ok {
+		return fmt.Errorf("%s is not listed in the manifest", rel)
+	}
+	if want == "" {
+		return nil
+	}
+
+	got, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%s does not match the manifest: sha256 is %s, expected %s", rel, got, want)
+	}
+	return nil
+}
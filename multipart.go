@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/cuipeiyu/cloudflare-r2-uploader/r2"
+	"github.com/spf13/cobra"
+)
+
+// maxPutSize is the largest object a single PutObject may create.
+const maxPutSize = 5 << 30
+
+// multipart reports whether a body of length bytes and type mimeType is sent
+// as a multipart upload instead of a single PutObject.
+func (u *uploader) multipart(length int64, mimeType string) bool {
+	return u.multipartThreshold > 0 && length >= u.multipartThreshold && !u.singlePut(length, mimeType)
+}
+
+// singlePut reports whether --no-multipart-for-text sends a body with a
+// single PutObject whatever its size, so the ETag of a text file stays the
+// MD5 of its content.
+func (u *uploader) singlePut(length int64, mimeType string) bool {
+	return u.noMultipartForText && strings.HasPrefix(mimeType, "text/") && length <= maxPutSize
+}
+
+// r2Client returns the client of the r2 package the multipart uploads are
+// sent with, retrying through withRetry and tracking the open uploads for
+// exitInterrupted.
+func (u *uploader) r2Client() *r2.Client {
+	return &r2.Client{
+		S3:                u.client,
+		Bucket:            bucketName,
+		Retry:             withRetry,
+		RequestPayer:      requestPayer(),
+		KeepFailedUploads: u.keepFailedMultipart,
+		Multipart: func(key, uploadID string, done bool) {
+			if done {
+				untrackMultipartUpload(uploadID)
+			} else {
+				trackMultipartUpload(uploadID, bucketName, key)
+			}
+		},
+	}
+}
+
+// partOptions are the options of the parts of a multipart upload:
+// --part-size and --part-concurrency, --content-md5 and
+// --upload-content-sha256 computed per part, the --signed-header-secret
+// headers, and the file read through --read-buffer-size and --limit-rate.
+func (u *uploader) partOptions() *r2.PartOptions {
+	return &r2.PartOptions{
+		PartSize:    u.partSize,
+		Concurrency: u.partConcurrency,
+		Prepare: func(input *s3.UploadPartInput, part *io.SectionReader) ([]func(*s3.Options), error) {
+			var options []func(*s3.Options)
+			if u.runHeaders != nil {
+				options = append(options, u.runHeaders)
+			}
+			if u.contentMD5 {
+				h := md5.New()
+				if _, err := io.Copy(h, part); err != nil {
+					return nil, err
+				}
+				input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+			}
+			if u.contentSHA256 {
+				h := sha256.New()
+				if _, err := io.Copy(h, io.NewSectionReader(part, 0, part.Size())); err != nil {
+					return nil, err
+				}
+				options = append(options, withPayloadSHA256(hex.EncodeToString(h.Sum(nil))))
+			}
+			return options, nil
+		},
+		Body: func(ctx context.Context, body io.Reader) io.Reader {
+			if u.readBufferSize > 0 {
+				body = bufio.NewReaderSize(body, u.readBufferSize)
+			}
+			return u.limit(ctx, body)
+		},
+	}
+}
+
+// uploadMultipart uploads length bytes of file from offset as key, in parts
+// of --part-size sent --part-concurrency at a time. It returns the ETag of
+// the object.
+func (u *uploader) uploadMultipart(ctx context.Context, file *os.File, key string, offset, length int64, mimeType string, headers objectHeaders, progress func(int64, int64)) (string, error) {
+	if partSize := r2.PartSize(length, u.partSize); partSize != u.partSize {
+		debugf("Raising the part size of %s to %d bytes to stay within %d parts", key, partSize, r2.MaxParts)
+	}
+
+	client := u.r2Client()
+	parts := u.partOptions()
+	return u.multipartUpload(ctx, key, mimeType, headers, func(uploadID string) ([]types.CompletedPart, error) {
+		return client.UploadParts(ctx, file, key, uploadID, offset, length, parts, progress)
+	})
+}
+
+// multipartUpload creates a multipart upload of key, sends its parts with
+// sendParts and completes it. An upload that fails is aborted so its parts
+// do not linger in the bucket. It returns the ETag of the object.
+func (u *uploader) multipartUpload(ctx context.Context, key, mimeType string, headers objectHeaders, sendParts func(uploadID string) ([]types.CompletedPart, error)) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(key),
+		ContentType: aws.String(mimeType),
+	}
+	headers.applyMultipart(input)
+
+	out, err := u.r2Client().MultipartUpload(ctx, input, sendParts, u.putOptions...)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// partSizeFlag returns --part-size, or --part-size-mb in bytes. The two are
+// mutually exclusive.
+func partSizeFlag(cmd *cobra.Command) int64 {
+	if cmd.Flags().Changed("part-size-mb") {
+		mb, _ := cmd.Flags().GetInt64("part-size-mb")
+		return mb << 20
+	}
+	size, _ := cmd.Flags().GetInt64("part-size")
+	return size
+}
+
+// keepFailedMultipartFlag reports whether --no-abort-multipart-on-error, or
+// --abort-multipart-on-error=false, keeps the parts of failed uploads.
+func keepFailedMultipartFlag(cmd *cobra.Command) bool {
+	abort, _ := cmd.Flags().GetBool("abort-multipart-on-error")
+	keep, _ := cmd.Flags().GetBool("no-abort-multipart-on-error")
+	return keep || !abort
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestMultipartNoMultipartForText(t *testing.T) {
+	tests := []struct {
+		name     string
+		noText   bool
+		length   int64
+		mimeType string
+		want     bool
+	}{
+		{name: "below the threshold", length: 10, mimeType: "text/plain", want: false},
+		{name: "text", length: 200, mimeType: "text/html; charset=utf-8", want: true},
+		{name: "text with the flag", noText: true, length: 200, mimeType: "text/html; charset=utf-8", want: false},
+		{name: "binary with the flag", noText: true, length: 200, mimeType: "image/png", want: true},
+		{name: "text over 5 GiB with the flag", noText: true, length: maxPutSize + 1, mimeType: "text/plain", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &uploader{multipartThreshold: 100, noMultipartForText: tt.noText}
+			if got := u.multipart(tt.length, tt.mimeType); got != tt.want {
+				t.Errorf("multipart(%d, %q) = %v, want %v", tt.length, tt.mimeType, got, tt.want)
+			}
+		})
+	}
+}
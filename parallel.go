@@ -0,0 +1,36 @@
+package main
+
+import "sync"
+
+// uploadPool runs the uploads of a directory on a fixed number of workers.
+type uploadPool struct {
+	jobs chan [2]string
+	wg   sync.WaitGroup
+}
+
+// newUploadPool starts n workers calling upload with the path and key of
+// every added file.
+func newUploadPool(n int, upload func(path, key string)) *uploadPool {
+	p := &uploadPool{jobs: make(chan [2]string)}
+	for i := 0; i < n; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				upload(job[0], job[1])
+			}
+		}()
+	}
+	return p
+}
+
+// add queues a file, waiting until a worker is free.
+func (p *uploadPool) add(path, key string) {
+	p.jobs <- [2]string{path, key}
+}
+
+// wait lets the workers finish the queued files and stops them.
+func (p *uploadPool) wait() {
+	close(p.jobs)
+	p.wg.Wait()
+}
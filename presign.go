@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/cobra"
+)
+
+// maxPresignExpires is the longest validity SigV4 allows for a presigned URL.
+const maxPresignExpires = 7 * 24 * time.Hour
+
+func presignCmd() *cobra.Command {
+	presign := &cobra.Command{
+		Use:   "presign <key>",
+		Short: "print a presigned URL to download or upload an object",
+		Long:  "",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			expires, _ := cmd.Flags().GetDuration("expires")
+			method, _ := cmd.Flags().GetString("method")
+			contentType, _ := cmd.Flags().GetString("content-type")
+			noSign, _ := cmd.Flags().GetBool("no-sign-url")
+			publicBaseURL, _ := cmd.Flags().GetString("public-base-url")
+
+			if expires <= 0 || expires > maxPresignExpires {
+				log.Fatalf("--expires must be between 1s and %s", maxPresignExpires)
+			}
+			method = strings.ToUpper(method)
+			if method != "GET" && method != "PUT" {
+				log.Fatalf("unknown method %q, expected GET or PUT", method)
+			}
+			if contentType != "" && method != "PUT" {
+				log.Fatalln("--content-type only applies to --method PUT")
+			}
+			if noSign && method != "GET" {
+				log.Fatalln("--no-sign-url only applies to --method GET, uploads are always signed")
+			}
+			if publicBaseURL != "" && !noSign {
+				log.Fatalln("--public-base-url only applies to --no-sign-url")
+			}
+
+			key := strings.TrimLeft(args[0], "/")
+
+			// a public bucket serves its objects at <base>/<key>
+			if publicBaseURL != "" {
+				fmt.Println(strings.TrimRight(publicBaseURL, "/") + "/" + escapeKey(key))
+				return
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			presigner := s3.NewPresignClient(client, s3.WithPresignExpires(expires))
+
+			var req *v4.PresignedHTTPRequest
+			if method == "GET" {
+				req, err = presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+					Bucket:       aws.String(bucketName),
+					Key:          aws.String(key),
+					RequestPayer: requestPayer(),
+				})
+			} else {
+				input := &s3.PutObjectInput{
+					Bucket:       aws.String(bucketName),
+					Key:          aws.String(key),
+					RequestPayer: requestPayer(),
+				}
+				if contentType != "" {
+					input.ContentType = aws.String(contentType)
+				}
+				req, err = presigner.PresignPutObject(ctx, input)
+			}
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			if noSign {
+				// the endpoint, bucket and key of the request without the
+				// SigV4 query parameters
+				u, err := url.Parse(req.URL)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				u.RawQuery = ""
+				fmt.Println(u)
+				fmt.Fprintln(os.Stderr, "Warning: the bucket is not known to be public, the URL only works if it allows anonymous reads. Pass the public URL of the bucket, e.g. its r2.dev or custom domain, as --public-base-url.")
+				return
+			}
+
+			fmt.Println(req.URL)
+
+			// headers the client has to send for the signature to match
+			var names []string
+			for name := range req.SignedHeader {
+				if !strings.EqualFold(name, "Host") {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				for _, value := range req.SignedHeader[name] {
+					fmt.Fprintf(os.Stderr, "Send the header %s: %s\n", name, value)
+				}
+			}
+			fmt.Fprintf(os.Stderr, "Valid until %s\n", time.Now().Add(expires).Format(time.RFC3339))
+		},
+	}
+
+	presign.Flags().Duration("expires", time.Hour, "How long the URL stays valid, at most 168h (7 days).")
+	presign.Flags().String("method", "GET", "GET to download the object or PUT to upload it.")
+	presign.Flags().Bool("no-sign-url", false, "Print the plain URL of the object, without the SigV4 query parameters, for a public bucket.")
+	presign.Flags().String("public-base-url", "", "Public URL of the bucket, e.g. https://pub-<id>.r2.dev or a custom domain. --no-sign-url builds the URL from it instead of the API endpoint.")
+	presign.Flags().String("content-type", "", "Content-Type the upload of a PUT URL must be sent with, it is part of the signature.")
+
+	return presign
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ttyProgressInterval throttles the in-place progress line on a terminal.
+	ttyProgressInterval = 200 * time.Millisecond
+	// logProgressInterval throttles the plain log lines written otherwise.
+	logProgressInterval = 5 * time.Second
+	// jsonProgressInterval throttles the progress events of --progress json.
+	jsonProgressInterval = time.Second
+
+	// progressBarWidth is the number of cells of the bar on a terminal
+	progressBarWidth = 24
+)
+
+type ProgressReader struct {
+	reader   io.Reader
+	total    int64
+	read     int64
+	progress func(int64, int64)
+}
+
+func (pr *ProgressReader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	pr.read += int64(n)
+	if pr.progress != nil {
+		pr.progress(pr.read, pr.total)
+	}
+	return n, err
+}
+
+func NewProgressReader(reader io.Reader, total int64, progress func(int64, int64)) *ProgressReader {
+	return &ProgressReader{
+		reader:   reader,
+		total:    total,
+		progress: progress,
+	}
+}
+
+// progressPrinter renders the progress of a single transfer. On a terminal
+// it keeps rewriting one line, otherwise it falls back to periodic log lines.
+type progressPrinter struct {
+	name     string
+	tty      bool
+	interval time.Duration
+	last     time.Time
+	done     bool
+}
+
+func newProgressPrinter(name string) *progressPrinter {
+	p := &progressPrinter{
+		name:     name,
+		tty:      isTerminal(os.Stdout),
+		interval: logProgressInterval,
+	}
+	if p.tty {
+		p.interval = ttyProgressInterval
+	}
+	return p
+}
+
+// update matches the callback signature of NewProgressReader.
+func (p *progressPrinter) update(read, total int64) {
+	if p.done {
+		return
+	}
+
+	finished := read >= total
+	now := time.Now()
+	if !finished && now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+	p.done = finished
+
+	percent := 100.0
+	if total > 0 {
+		percent = 100 * float64(read) / float64(total)
+	}
+
+	if !p.tty {
+		log.Printf("%s: %d out of %d bytes (%.2f%%)", p.name, read, total, percent)
+		return
+	}
+
+	fmt.Printf("\r\033[K%s: %d out of %d bytes (%.2f%%)", p.name, read, total, percent)
+	if finished {
+		fmt.Println()
+	}
+}
+
+// isTerminal reports whether f is attached to a character device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// compactProgress renders a single summary line for a whole upload run:
+//
+//	[12/87 files] [34.5 MB / 120 MB] [5.2 MB/s] [ETA 1m23s]
+//
+// On a terminal the line starts with a bar of the bytes done and ends with
+// the file being sent.
+type compactProgress struct {
+	totalFiles int
+	totalBytes int64
+
+	files       int
+	bytes       int64
+	transferred int64
+	// current is the key of the file that was read from last
+	current string
+
+	tty      bool
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+
+	// mu serializes the updates of parallel uploads
+	mu sync.Mutex
+}
+
+func newCompactProgress(totalFiles int, totalBytes int64) *compactProgress {
+	c := &compactProgress{
+		totalFiles: totalFiles,
+		totalBytes: totalBytes,
+		tty:        isTerminal(os.Stdout),
+		interval:   logProgressInterval,
+		start:      time.Now(),
+	}
+	if c.tty {
+		c.interval = ttyProgressInterval
+	}
+	return c
+}
+
+// reader returns a callback for NewProgressReader that feeds the bytes read
+// from the file uploaded as key into the run totals.
+func (c *compactProgress) reader(key string) func(int64, int64) {
+	var prev int64
+	return func(read, total int64) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.bytes += read - prev
+		c.transferred += read - prev
+		prev = read
+		c.current = key
+		c.render(false)
+	}
+}
+
+// skip accounts for a file that is not transferred at all.
+func (c *compactProgress) skip(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bytes += size
+	c.files++
+	c.render(false)
+}
+
+// done marks the current file as finished.
+func (c *compactProgress) done() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files++
+	c.render(false)
+}
+
+// finish prints the final state and terminates the line.
+func (c *compactProgress) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.render(true)
+	if c.tty {
+		fmt.Println()
+	}
+}
+
+func (c *compactProgress) render(force bool) {
+	now := time.Now()
+	if !force && now.Sub(c.last) < c.interval {
+		return
+	}
+	c.last = now
+
+	var speed float64
+	if elapsed := now.Sub(c.start).Seconds(); elapsed > 0 {
+		speed = float64(c.transferred) / elapsed
+	}
+
+	eta := "-"
+	if speed > 0 && c.totalBytes > c.bytes {
+		remaining := float64(c.totalBytes-c.bytes) / speed
+		eta = time.Duration(remaining * float64(time.Second)).Round(time.Second).String()
+	}
+
+	line := fmt.Sprintf("[%d/%d files] [%s / %s] [%s/s] [ETA %s]",
+		c.files, c.totalFiles, formatBytes(c.bytes), formatBytes(c.totalBytes), formatBytes(int64(speed)), eta)
+
+	if !c.tty {
+		log.Println(line)
+		return
+	}
+	// keep the line short enough not to wrap, which would break the \r
+	current := c.current
+	if len(current) > 40 {
+		current = "..." + current[len(current)-37:]
+	}
+	fmt.Printf("\r\033[K%s %s %s", progressBar(c.bytes, c.totalBytes), line, current)
+}
+
+// progressBar draws done out of total as "[=======>        ] 42%".
+func progressBar(done, total int64) string {
+	fraction := 1.0
+	if total > 0 {
+		fraction = math.Min(float64(done)/float64(total), 1)
+	}
+
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %3.0f%%", bar, 100*fraction)
+}
+
+// formatBytes formats n with a binary unit suffix, e.g. "34.5 MB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// scanDir counts the regular files below root and their total size, leaving
+// out what exclude returns true for. It walks the tree like the upload does,
+// following symlinks with follow.
+func scanDir(root string, follow bool, exclude func(path string, info fs.FileInfo) bool) (files int, size int64, err error) {
+	// the upload reports the skipped links, they are not counted
+	skip := func(path, why string) {}
+	err = walkFiles(root, follow, skip, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if exclude(path, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size, err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxDeleteObjects is the number of keys DeleteObjects accepts per request.
+const maxDeleteObjects = 1000
+
+// listKeys returns the keys of every object below prefix.
+func listKeys(ctx context.Context, client *s3.Client, prefix string) ([]string, error) {
+	var keys []string
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:       aws.String(bucketName),
+		Prefix:       aws.String(prefix),
+		RequestPayer: requestPayer(),
+	})
+	for paginator.HasMorePages() {
+		var page *s3.ListObjectsV2Output
+		err := withRetry(ctx, func() (err error) {
+			page, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	return keys, nil
+}
+
+// deleteObjects deletes keys in batches of batchSize, at most
+// maxDeleteObjects. The first key that could not be deleted is reported as
+// the error.
+func deleteObjects(ctx context.Context, client *s3.Client, keys []string, batchSize int) error {
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		var out *s3.DeleteObjectsOutput
+		err := withRetry(ctx, func() (err error) {
+			out, err = client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket:       aws.String(bucketName),
+				Delete:       &types.Delete{Objects: objects, Quiet: true},
+				RequestPayer: requestPayer(),
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("delete %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+	}
+	return nil
+}
+
+// deleteMissing deletes the objects below remotePath that the manifest does
+// not list. With dryRun it only logs them.
+func (u *uploader) deleteMissing(ctx context.Context, remotePath string) error {
+	prefix := remotePath
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	prefix = u.namespaced(prefix)
+
+	// the keys the manifest files are uploaded to
+	wanted := make(map[string]bool, len(u.manifest))
+	for rel := range u.manifest {
+		derived := strings.TrimPrefix(path.Join(remotePath, rel), "/")
+		wanted[u.keyFor(filepath.Join(u.root, filepath.FromSlash(rel)), derived)] = true
+	}
+
+	return u.deleteUnwanted(ctx, prefix, wanted, "missing from the manifest")
+}
+
+// deleteUnwanted deletes the objects below prefix whose key is not in wanted,
+// why describes them in the log. With dryRun it only logs them.
+func (u *uploader) deleteUnwanted(ctx context.Context, prefix string, wanted map[string]bool, why string) error {
+	existing, err := listKeys(ctx, u.client, prefix)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, key := range existing {
+		if !wanted[key] {
+			missing = append(missing, key)
+		}
+	}
+
+	if u.dryRun {
+		for _, key := range missing {
+			log.Printf("Would delete %s", key)
+		}
+		return nil
+	}
+
+	if len(missing) == 0 || !confirmDelete(missing) {
+		return nil
+	}
+
+	if err := deleteObjects(ctx, u.client, missing, maxDeleteObjects); err != nil {
+		return err
+	}
+	if !u.quiet {
+		log.Printf("Deleted %d objects %s", len(missing), why)
+	}
+	return nil
+}
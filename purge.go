@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// cloudflareAPI is the base URL of the Cloudflare v4 API.
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// purgeEverything drops every cached file of the Cloudflare zone, so the
+// uploaded objects are served fresh through the zone's custom domain.
+func purgeEverything(ctx context.Context, zoneID, token string) error {
+	body, err := json.Marshal(map[string]bool{"purge_everything": true})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/zones/%s/purge_cache", cloudflareAPI, zoneID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("purge cache: %s: %s", resp.Status, data)
+	}
+	if !result.Success {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("purge cache: %s (code %d)", result.Errors[0].Message, result.Errors[0].Code)
+		}
+		return fmt.Errorf("purge cache: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Package r2 uploads files and directories to a Cloudflare R2 bucket. It is
+// the library the cloudflare-r2-uploader command builds its client on, for
+// programs that want to upload to R2 without running the command.
+//
+//	client, err := r2.NewClient(ctx, r2.Config{
+//		AccountID:       "0123456789abcdef",
+//		AccessKeyID:     "...",
+//		SecretAccessKey: "...",
+//		Bucket:          "assets",
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	err = client.UploadDir(ctx, "dist", "static", &r2.UploadOptions{
+//		Progress: func(key string, sent, total int64) { ... },
+//	})
+package r2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// DefaultRegion is the region R2 accepts for every bucket.
+const DefaultRegion = "auto"
+
+// ErrInvalidConfig is wrapped by the errors of NewClient about a Config that
+// misses a required value.
+var ErrInvalidConfig = errors.New("invalid R2 config")
+
+// Config holds the account, credentials and bucket of a Client.
+type Config struct {
+	// AccountID picks the endpoint https://<AccountID>.r2.cloudflarestorage.com
+	AccountID       string
+	AccessKeyID     string
+	SecretAccessKey string
+	Bucket          string
+
+	// Endpoint replaces the endpoint of AccountID, e.g. for an S3 compatible
+	// server in tests
+	Endpoint string
+	// Region defaults to the AWS_REGION environment and then DefaultRegion
+	Region string
+	// UsePathStyle puts the bucket into the URL path instead of the host name
+	UsePathStyle bool
+
+	// HTTPClient replaces the HTTP client of the SDK
+	HTTPClient config.HTTPClient
+	// Retryer replaces the retries of the SDK
+	Retryer func() aws.Retryer
+	// APIOptions are added to the middleware stack of every request
+	APIOptions []func(*middleware.Stack) error
+}
+
+// Client uploads to the bucket of its Config.
+type Client struct {
+	// S3 is the underlying client, for the requests Client has no method for
+	S3     *s3.Client
+	Bucket string
+
+	// Retry, if set, sends every request of an upload by calling fn until it
+	// gives up, fn builds a fresh body each time. Without it the retryer of
+	// S3 retries the requests.
+	Retry func(ctx context.Context, fn func() error) error
+	// RequestPayer is sent with the requests of uploads, for requester-pays
+	// buckets
+	RequestPayer types.RequestPayer
+	// Multipart is called when a multipart upload is created and again with
+	// done once it is completed or aborted, e.g. to abort the uploads still
+	// open when the program is interrupted
+	Multipart func(key, uploadID string, done bool)
+	// KeepFailedUploads leaves the parts of a failed multipart upload in
+	// the bucket instead of aborting it, e.g. to inspect them. The upload
+	// is reported as a *KeptUploadError. An upload failing because its
+	// context is done is still aborted.
+	KeepFailedUploads bool
+}
+
+// NewClient builds a Client talking to the R2 endpoint of cfg.
+func NewClient(ctx context.Context, cfg Config) (*Client, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		if cfg.AccountID == "" {
+			return nil, fmt.Errorf("%w: the account ID or an endpoint is required", ErrInvalidConfig)
+		}
+		endpoint = fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cfg.AccountID)
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("%w: the access key ID and secret access key are required", ErrInvalidConfig)
+	}
+
+	resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		return aws.Endpoint{URL: endpoint}, nil
+	})
+
+	options := []func(*config.LoadOptions) error{
+		config.WithEndpointResolverWithOptions(resolver),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")),
+		config.WithAPIOptions(cfg.APIOptions),
+	}
+	if cfg.Region != "" {
+		options = append(options, config.WithRegion(cfg.Region))
+	}
+	if cfg.HTTPClient != nil {
+		options = append(options, config.WithHTTPClient(cfg.HTTPClient))
+	}
+	if cfg.Retryer != nil {
+		options = append(options, config.WithRetryer(cfg.Retryer))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = DefaultRegion
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.UsePathStyle = cfg.UsePathStyle
+	})
+	return &Client{S3: client, Bucket: cfg.Bucket}, nil
+}
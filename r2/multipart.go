@@ -0,0 +1,319 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// MinPartSize is the smallest part R2 accepts, except for the last one.
+	MinPartSize = 5 << 20
+	// MaxParts is the most parts a multipart upload may have.
+	MaxParts = 10000
+
+	// abortTimeout bounds the abort of a failed multipart upload, which is
+	// sent even when the context of the upload is done.
+	abortTimeout = 30 * time.Second
+)
+
+// PartSize returns the part size of a multipart upload of length bytes:
+// partSize, raised as needed to stay within MaxParts parts.
+func PartSize(length, partSize int64) int64 {
+	if (length+partSize-1)/partSize > MaxParts {
+		return (length + MaxParts - 1) / MaxParts
+	}
+	return partSize
+}
+
+// AbortError is returned by MultipartUpload when a failed upload could not
+// be aborted either. Its parts stay in the bucket until the upload is
+// aborted.
+type AbortError struct {
+	Key      string
+	UploadID string
+	// Err made the upload fail
+	Err      error
+	AbortErr error
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("%s (aborting the upload of %s failed too: %s)", e.Err, e.Key, e.AbortErr)
+}
+
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+// KeptUploadError is returned by MultipartUpload for a failed upload that
+// KeepFailedUploads left in the bucket. Its parts stay there until the
+// upload is aborted.
+type KeptUploadError struct {
+	Key      string
+	UploadID string
+	Err      error
+}
+
+func (e *KeptUploadError) Error() string {
+	return fmt.Sprintf("%s (the parts of %s are kept as upload %s)", e.Err, e.Key, e.UploadID)
+}
+
+func (e *KeptUploadError) Unwrap() error {
+	return e.Err
+}
+
+// retry sends a request with fn through c.Retry, if any.
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+	if c.Retry == nil {
+		return fn()
+	}
+	return c.Retry(ctx, fn)
+}
+
+// MultipartUpload creates a multipart upload from input, sends its parts with
+// sendParts and completes it, the options applying to the completion. An
+// upload that fails is aborted so its parts do not linger in the bucket,
+// unless KeepFailedUploads is set.
+func (c *Client) MultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, sendParts func(uploadID string) ([]types.CompletedPart, error), optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	if input.Bucket == nil {
+		input.Bucket = aws.String(c.Bucket)
+	}
+	input.RequestPayer = c.RequestPayer
+	key := aws.ToString(input.Key)
+
+	var created *s3.CreateMultipartUploadOutput
+	err := c.retry(ctx, func() (err error) {
+		created, err = c.S3.CreateMultipartUpload(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	uploadID := aws.ToString(created.UploadId)
+	if c.Multipart != nil {
+		c.Multipart(key, uploadID, false)
+	}
+
+	parts, err := sendParts(uploadID)
+	if err == nil {
+		var out *s3.CompleteMultipartUploadOutput
+		err = c.retry(ctx, func() (err error) {
+			out, err = c.S3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+				Bucket:          input.Bucket,
+				Key:             input.Key,
+				UploadId:        aws.String(uploadID),
+				RequestPayer:    c.RequestPayer,
+				MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+			}, optFns...)
+			return err
+		})
+		if err == nil {
+			if c.Multipart != nil {
+				c.Multipart(key, uploadID, true)
+			}
+			return out, nil
+		}
+	}
+
+	if c.KeepFailedUploads && ctx.Err() == nil {
+		if c.Multipart != nil {
+			c.Multipart(key, uploadID, true)
+		}
+		return nil, &KeptUploadError{Key: key, UploadID: uploadID, Err: err}
+	}
+
+	// ctx may be done already, the abort gets a context of its own
+	abortCtx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+
+	_, abortErr := c.S3.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+		Bucket:       input.Bucket,
+		Key:          input.Key,
+		UploadId:     aws.String(uploadID),
+		RequestPayer: c.RequestPayer,
+	})
+	if abortErr != nil {
+		return nil, &AbortError{Key: key, UploadID: uploadID, Err: err, AbortErr: abortErr}
+	}
+	if c.Multipart != nil {
+		c.Multipart(key, uploadID, true)
+	}
+	return nil, err
+}
+
+// PartOptions tune the parts sent by UploadParts and UploadPart.
+type PartOptions struct {
+	// PartSize is raised to stay within MaxParts, see PartSize
+	PartSize int64
+	// Concurrency is the number of parts sent at once
+	Concurrency int
+
+	// Prepare is called with the input of every part and a reader of its
+	// bytes before the part is sent, e.g. to set a ContentMD5. It returns
+	// the options of the request.
+	Prepare func(input *s3.UploadPartInput, part *io.SectionReader) ([]func(*s3.Options), error)
+	// Body wraps the body of every attempt to send a part, e.g. to buffer
+	// it or limit the rate it is read at
+	Body func(ctx context.Context, body io.Reader) io.Reader
+}
+
+// UploadParts sends length bytes of r from offset as the parts of the
+// multipart upload uploadID of key, Concurrency at a time, and returns them
+// in order. The first failed part cancels the others. progress, if not nil,
+// is called with the bytes of the parts sent so far and length.
+func (c *Client) UploadParts(ctx context.Context, r io.ReaderAt, key, uploadID string, offset, length int64, o *PartOptions, progress func(sent, total int64)) ([]types.CompletedPart, error) {
+	partSize := PartSize(length, o.PartSize)
+	count := int((length + partSize - 1) / partSize)
+	parts := make([]types.CompletedPart, count)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := o.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		sent     int64
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		start := int64(i) * partSize
+		size := partSize
+		if start+size > length {
+			size = length - start
+		}
+
+		// sums the bytes of the parts in flight for progress, a retried
+		// part starts over and takes its bytes back
+		var prev int64
+		report := func(read int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			sent += read - prev
+			prev = read
+			if progress != nil {
+				progress(sent, length)
+			}
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(number int32) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			etag, err := c.UploadPart(ctx, r, key, uploadID, number, offset+start, size, o, report)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			parts[number-1] = types.CompletedPart{ETag: aws.String(etag), PartNumber: number}
+		}(int32(i + 1))
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return parts, firstErr
+}
+
+// UploadPart sends size bytes of r from offset as part number of the upload
+// and returns its ETag. progress, if not nil, is called with the bytes of
+// the part read so far by the current attempt.
+func (c *Client) UploadPart(ctx context.Context, r io.ReaderAt, key, uploadID string, number int32, offset, size int64, o *PartOptions, progress func(read int64)) (string, error) {
+	input := &s3.UploadPartInput{
+		Bucket:        aws.String(c.Bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    number,
+		RequestPayer:  c.RequestPayer,
+		ContentLength: size,
+	}
+	var options []func(*s3.Options)
+	if o.Prepare != nil {
+		var err error
+		if options, err = o.Prepare(input, io.NewSectionReader(r, offset, size)); err != nil {
+			return "", err
+		}
+	}
+
+	var etag string
+	err := c.retry(ctx, func() error {
+		// a fresh section reader, so a retry sends the whole part again
+		var body io.Reader = io.NewSectionReader(r, offset, size)
+		if o.Body != nil {
+			body = o.Body(ctx, body)
+		}
+		input.Body = countReads(body, progress)
+
+		out, err := c.S3.UploadPart(ctx, input, options...)
+		if err != nil {
+			return err
+		}
+		etag = aws.ToString(out.ETag)
+		return nil
+	})
+	return etag, err
+}
+
+// countingReader reports the bytes read from r so far.
+type countingReader struct {
+	r      io.Reader
+	read   int64
+	report func(read int64)
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if n > 0 {
+		c.read += int64(n)
+		c.report(c.read)
+	}
+	return n, err
+}
+
+// countingSeeker is a countingReader of a seekable body. Seeking back, as
+// the SDK does to retry a request, takes the bytes back.
+type countingSeeker struct {
+	countingReader
+	s io.Seeker
+}
+
+func (c *countingSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.s.Seek(offset, whence)
+	if err == nil {
+		c.read = pos
+	}
+	return pos, err
+}
+
+// countReads wraps body to call report with the bytes read, keeping it
+// seekable if it is. A nil report leaves body alone.
+func countReads(body io.Reader, report func(read int64)) io.Reader {
+	if report == nil {
+		return body
+	}
+	c := countingReader{r: body, report: report}
+	if s, ok := body.(io.Seeker); ok {
+		return &countingSeeker{countingReader: c, s: s}
+	}
+	return &c
+}
@@ -0,0 +1,224 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// stubStatus fails the requests of an operation with the status, if set.
+type stubStatus struct {
+	putStatus      int
+	partStatus     int
+	completeStatus int
+	abortStatus    int
+}
+
+// stubS3 answers the requests of an upload like R2 does, failing the
+// operations given a stubStatus.
+type stubS3 struct {
+	stubStatus
+	// failKeys fail the PutObject of these keys with a 500
+	failKeys map[string]bool
+
+	mu     sync.Mutex
+	puts   []string
+	aborts []string
+}
+
+func (s *stubS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// path style: /<bucket>/<key>
+	key := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[1]
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fail := func(status int) bool {
+		if status == 0 || status == http.StatusOK {
+			return false
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<Error><Code>Stub%d</Code><Message>stub failure</Message></Error>", status)
+		return true
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>", key)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		if !fail(s.partStatus) {
+			w.Header().Set("ETag", `"part-`+query.Get("partNumber")+`"`)
+		}
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		if !fail(s.completeStatus) {
+			fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key><ETag>\"etag-1\"</ETag></CompleteMultipartUploadResult>", key)
+		}
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.aborts = append(s.aborts, query.Get("uploadId"))
+		if !fail(s.abortStatus) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case r.Method == http.MethodPut:
+		if s.failKeys[key] {
+			fail(http.StatusInternalServerError)
+			return
+		}
+		if !fail(s.putStatus) {
+			s.puts = append(s.puts, key)
+			w.Header().Set("ETag", `"etag"`)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// newStubClient returns a Client of bucket "bk" sending its requests to
+// stub, without retries.
+func newStubClient(t *testing.T, stub *stubS3) *Client {
+	t.Helper()
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	client, err := NewClient(context.Background(), Config{
+		Endpoint:        srv.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		Bucket:          "bk",
+		Region:          DefaultRegion,
+		UsePathStyle:    true,
+		Retryer:         func() aws.Retryer { return aws.NopRetryer{} },
+		// the payload of a plain HTTP request must not be signed
+		APIOptions: []func(*middleware.Stack) error{v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestPartSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		length   int64
+		partSize int64
+		want     int64
+	}{
+		{name: "one part", length: 1 << 20, partSize: MinPartSize, want: MinPartSize},
+		{name: "within MaxParts", length: MaxParts * MinPartSize, partSize: MinPartSize, want: MinPartSize},
+		{name: "one byte over MaxParts", length: MaxParts*MinPartSize + 1, partSize: MinPartSize, want: MinPartSize + 1},
+		{name: "large file", length: 1 << 40, partSize: 8 << 20, want: (1<<40 + MaxParts - 1) / MaxParts},
+		{name: "empty", length: 0, partSize: MinPartSize, want: MinPartSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PartSize(tt.length, tt.partSize)
+			if got != tt.want {
+				t.Errorf("PartSize(%d, %d) = %d, want %d", tt.length, tt.partSize, got, tt.want)
+			}
+			if tt.length > 0 && (tt.length+got-1)/got > MaxParts {
+				t.Errorf("PartSize(%d, %d) = %d makes more than %d parts", tt.length, tt.partSize, got, MaxParts)
+			}
+		})
+	}
+}
+
+func TestMultipartUploadFailure(t *testing.T) {
+	errParts := errors.New("parts failed")
+
+	tests := []struct {
+		name           string
+		status         stubStatus
+		partsErr       error
+		keep           bool
+		cancel         bool
+		wantErr        error
+		wantAborts     int
+		wantAbortError bool
+		wantKept       bool
+	}{
+		{name: "success", wantAborts: 0},
+		{name: "parts fail", partsErr: errParts, wantErr: errParts, wantAborts: 1},
+		{name: "complete fails", status: stubStatus{completeStatus: 500}, wantAborts: 1},
+		{name: "abort fails", status: stubStatus{abortStatus: 500}, partsErr: errParts, wantErr: errParts, wantAborts: 1, wantAbortError: true},
+		{name: "kept", partsErr: errParts, keep: true, wantErr: errParts, wantKept: true},
+		{name: "kept but cancelled", partsErr: errParts, keep: true, cancel: true, wantErr: errParts, wantAborts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubS3{stubStatus: tt.status}
+			client := newStubClient(t, stub)
+			client.KeepFailedUploads = tt.keep
+
+			var calls []bool
+			client.Multipart = func(key, uploadID string, done bool) {
+				if key != "big.bin" || uploadID != "upload-1" {
+					t.Errorf("Multipart(%q, %q, %v), want big.bin and upload-1", key, uploadID, done)
+				}
+				calls = append(calls, done)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, err := client.MultipartUpload(ctx, &s3.CreateMultipartUploadInput{Key: aws.String("big.bin")}, func(uploadID string) ([]types.CompletedPart, error) {
+				if tt.cancel {
+					cancel()
+				}
+				return []types.CompletedPart{{ETag: aws.String(`"part-1"`), PartNumber: 1}}, tt.partsErr
+			})
+
+			wantFail := tt.partsErr != nil || tt.status.completeStatus != 0
+			if (err != nil) != wantFail {
+				t.Fatalf("MultipartUpload() error = %v, want error %v", err, wantFail)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("MultipartUpload() error = %v, want it to wrap %v", err, tt.wantErr)
+			}
+			if tt.status.completeStatus != 0 {
+				var re *smithyhttp.ResponseError
+				if !errors.As(err, &re) || re.HTTPStatusCode() != tt.status.completeStatus {
+					t.Errorf("MultipartUpload() error = %v, want the %d response", err, tt.status.completeStatus)
+				}
+			}
+			if len(stub.aborts) != tt.wantAborts {
+				t.Errorf("%d aborts, want %d", len(stub.aborts), tt.wantAborts)
+			}
+
+			var abortErr *AbortError
+			if errors.As(err, &abortErr) != tt.wantAbortError {
+				t.Errorf("MultipartUpload() error = %v (%T), want *AbortError %v", err, err, tt.wantAbortError)
+			}
+			if tt.wantAbortError && (abortErr.UploadID != "upload-1" || abortErr.AbortErr == nil) {
+				t.Errorf("AbortError = %+v, want upload-1 and the abort error", abortErr)
+			}
+			var kept *KeptUploadError
+			if errors.As(err, &kept) != tt.wantKept {
+				t.Errorf("MultipartUpload() error = %v (%T), want *KeptUploadError %v", err, err, tt.wantKept)
+			}
+			if tt.wantKept && (kept.Key != "big.bin" || kept.UploadID != "upload-1") {
+				t.Errorf("KeptUploadError = %+v, want big.bin and upload-1", kept)
+			}
+
+			// created, then done unless the upload is still open
+			wantCalls := []bool{false, true}
+			if tt.wantAbortError {
+				wantCalls = []bool{false}
+			}
+			if fmt.Sprint(calls) != fmt.Sprint(wantCalls) {
+				t.Errorf("Multipart called with done %v, want %v", calls, wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// DefaultMultipartThreshold is the size from which files are sent as
+	// multipart uploads.
+	DefaultMultipartThreshold = 100 << 20
+	// DefaultPartSize is the size of the parts of a multipart upload.
+	DefaultPartSize = 16 << 20
+	// DefaultConcurrency is the number of files UploadDir sends at once, and
+	// the number of parts of a file sent at once.
+	DefaultConcurrency = 4
+)
+
+// ProgressFunc is called while the body of key is sent, with the bytes sent
+// so far and the size of the file. UploadDir calls it from several
+// goroutines at once.
+type ProgressFunc func(key string, sent, total int64)
+
+// UploadOptions tune UploadFile and UploadDir, the zero value and nil use
+// the defaults.
+type UploadOptions struct {
+	// ContentType of the objects, guessed from the extension and then the
+	// content of each file when empty
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+
+	// MultipartThreshold is the size from which files are sent in parts of
+	// PartSize, negative always uses a single PutObject
+	MultipartThreshold int64
+	PartSize           int64
+	// Concurrency is the number of files UploadDir sends at once
+	Concurrency int
+	// PartConcurrency is the number of parts of a file sent at once
+	PartConcurrency int
+
+	Progress ProgressFunc
+}
+
+func (o *UploadOptions) withDefaults() UploadOptions {
+	opts := UploadOptions{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.MultipartThreshold == 0 {
+		opts.MultipartThreshold = DefaultMultipartThreshold
+	}
+	if opts.PartSize < MinPartSize {
+		opts.PartSize = DefaultPartSize
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.PartConcurrency < 1 {
+		opts.PartConcurrency = DefaultConcurrency
+	}
+	return opts
+}
+
+// UploadError is the error of one file, returned by UploadFile and collected
+// in a DirError by UploadDir.
+type UploadError struct {
+	Path string
+	Key  string
+	Err  error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("upload %s to %s: %s", e.Path, e.Key, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// DirError lists the files UploadDir failed to upload, the others were
+// uploaded.
+type DirError struct {
+	Failed []*UploadError
+}
+
+func (e *DirError) Error() string {
+	if len(e.Failed) == 1 {
+		return e.Failed[0].Error()
+	}
+	return fmt.Sprintf("%d files failed, the first: %s", len(e.Failed), e.Failed[0])
+}
+
+// UploadFile uploads the file at path as key.
+func (c *Client) UploadFile(ctx context.Context, path, key string, opts *UploadOptions) error {
+	o := opts.withDefaults()
+	if err := c.uploadFile(ctx, path, key, &o); err != nil {
+		return &UploadError{Path: path, Key: key, Err: err}
+	}
+	return nil
+}
+
+// UploadDir uploads the regular files below dir, each as prefix followed by
+// its slash separated path relative to dir. It keeps going after a failed
+// file and then returns a *DirError.
+func (c *Client) UploadDir(ctx context.Context, dir, prefix string, opts *UploadOptions) error {
+	o := opts.withDefaults()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []*UploadError
+	)
+	fail := func(path, key string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, &UploadError{Path: path, Key: key, Err: err})
+	}
+
+	sem := make(chan struct{}, o.Concurrency)
+	walkErr := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			fail(p, "", err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			fail(p, "", err)
+			return nil
+		}
+		key := strings.TrimPrefix(path.Join(prefix, filepath.ToSlash(rel)), "/")
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := c.uploadFile(ctx, p, key, &o); err != nil {
+				fail(p, key, err)
+			}
+		}()
+		return nil
+	})
+	wg.Wait()
+
+	if walkErr != nil {
+		return walkErr
+	}
+	if len(failed) > 0 {
+		return &DirError{Failed: failed}
+	}
+	return nil
+}
+
+func (c *Client) uploadFile(ctx context.Context, path, key string, o *UploadOptions) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	contentType := o.ContentType
+	if contentType == "" {
+		contentType = detectContentType(file, path)
+	}
+
+	if o.MultipartThreshold > 0 && size >= o.MultipartThreshold {
+		return c.uploadMultipart(ctx, file, key, size, contentType, o)
+	}
+
+	var progress func(int64)
+	if o.Progress != nil {
+		progress = func(read int64) { o.Progress(key, read, size) }
+	}
+	return c.retry(ctx, func() error {
+		input := &s3.PutObjectInput{
+			Bucket:        aws.String(c.Bucket),
+			Key:           aws.String(key),
+			RequestPayer:  c.RequestPayer,
+			Body:          countReads(io.NewSectionReader(file, 0, size), progress),
+			ContentLength: size,
+			ContentType:   aws.String(contentType),
+			Metadata:      o.Metadata,
+		}
+		if o.CacheControl != "" {
+			input.CacheControl = aws.String(o.CacheControl)
+		}
+		_, err := c.S3.PutObject(ctx, input)
+		return err
+	})
+}
+
+// uploadMultipart sends the file in parts of o.PartSize, o.PartConcurrency at
+// a time.
+func (c *Client) uploadMultipart(ctx context.Context, file *os.File, key string, size int64, contentType string, o *UploadOptions) error {
+	input := &s3.CreateMultipartUploadInput{
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Metadata:    o.Metadata,
+	}
+	if o.CacheControl != "" {
+		input.CacheControl = aws.String(o.CacheControl)
+	}
+
+	var progress func(sent, total int64)
+	if o.Progress != nil {
+		progress = func(sent, total int64) { o.Progress(key, sent, total) }
+	}
+	parts := &PartOptions{PartSize: o.PartSize, Concurrency: o.PartConcurrency}
+	_, err := c.MultipartUpload(ctx, input, func(uploadID string) ([]types.CompletedPart, error) {
+		return c.UploadParts(ctx, file, key, uploadID, 0, size, parts, progress)
+	})
+	return err
+}
+
+// detectContentType guesses the MIME type of the file from the extension of
+// path, else from its first bytes.
+func detectContentType(file io.ReaderAt, path string) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	return http.DetectContentType(head[:n])
+}
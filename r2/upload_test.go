@@ -0,0 +1,168 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestNewClientInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "account", cfg: Config{AccountID: "acct", AccessKeyID: "key", SecretAccessKey: "secret"}},
+		{name: "endpoint", cfg: Config{Endpoint: "http://localhost:9000", AccessKeyID: "key", SecretAccessKey: "secret"}},
+		{name: "no account or endpoint", cfg: Config{AccessKeyID: "key", SecretAccessKey: "secret"}, wantErr: true},
+		{name: "no access key", cfg: Config{AccountID: "acct", SecretAccessKey: "secret"}, wantErr: true},
+		{name: "no secret key", cfg: Config{AccountID: "acct", AccessKeyID: "key"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClient(context.Background(), tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("NewClient() error = %v, want it to wrap ErrInvalidConfig", err)
+			}
+		})
+	}
+}
+
+func TestUploadFileError(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         stubStatus
+		threshold      int64
+		wantStatus     int
+		wantAborts     int
+		wantAbortError bool
+	}{
+		{name: "put", status: stubStatus{putStatus: 500}, wantStatus: 500},
+		{name: "put access denied", status: stubStatus{putStatus: 403}, wantStatus: 403},
+		{name: "part", status: stubStatus{partStatus: 500}, threshold: 1, wantStatus: 500, wantAborts: 1},
+		{name: "part and abort", status: stubStatus{partStatus: 500, abortStatus: 500}, threshold: 1, wantStatus: 500, wantAborts: 1, wantAbortError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubS3{stubStatus: tt.status}
+			client := newStubClient(t, stub)
+
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := client.UploadFile(context.Background(), path, "dir/file.txt", &UploadOptions{MultipartThreshold: tt.threshold})
+			var uploadErr *UploadError
+			if !errors.As(err, &uploadErr) {
+				t.Fatalf("UploadFile() error = %v (%T), want *UploadError", err, err)
+			}
+			if uploadErr.Path != path || uploadErr.Key != "dir/file.txt" {
+				t.Errorf("UploadError = %+v, want %s and dir/file.txt", uploadErr, path)
+			}
+			var re *smithyhttp.ResponseError
+			if !errors.As(err, &re) || re.HTTPStatusCode() != tt.wantStatus {
+				t.Errorf("UploadFile() error = %v, want the %d response", err, tt.wantStatus)
+			}
+			var abortErr *AbortError
+			if errors.As(err, &abortErr) != tt.wantAbortError {
+				t.Errorf("UploadFile() error = %v, want *AbortError %v", err, tt.wantAbortError)
+			}
+			if len(stub.aborts) != tt.wantAborts {
+				t.Errorf("%d aborts, want %d", len(stub.aborts), tt.wantAborts)
+			}
+		})
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/c.txt"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		prefix     string
+		failKeys   map[string]bool
+		wantPuts   []string
+		wantFailed []string
+	}{
+		{name: "all uploaded", prefix: "static", wantPuts: []string{"static/a.txt", "static/sub/b.txt", "static/sub/c.txt"}},
+		{name: "no prefix", wantPuts: []string{"a.txt", "sub/b.txt", "sub/c.txt"}},
+		{
+			name:       "one fails",
+			prefix:     "static",
+			failKeys:   map[string]bool{"static/sub/b.txt": true},
+			wantPuts:   []string{"static/a.txt", "static/sub/c.txt"},
+			wantFailed: []string{"static/sub/b.txt"},
+		},
+		{
+			name:       "two fail",
+			failKeys:   map[string]bool{"a.txt": true, "sub/c.txt": true},
+			wantPuts:   []string{"sub/b.txt"},
+			wantFailed: []string{"a.txt", "sub/c.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubS3{failKeys: tt.failKeys}
+			client := newStubClient(t, stub)
+
+			err := client.UploadDir(context.Background(), dir, tt.prefix, &UploadOptions{Concurrency: 2})
+
+			sort.Strings(stub.puts)
+			if !equalStrings(stub.puts, tt.wantPuts) {
+				t.Errorf("uploaded %q, want %q", stub.puts, tt.wantPuts)
+			}
+
+			if tt.wantFailed == nil {
+				if err != nil {
+					t.Fatalf("UploadDir() error = %v", err)
+				}
+				return
+			}
+			var dirErr *DirError
+			if !errors.As(err, &dirErr) {
+				t.Fatalf("UploadDir() error = %v (%T), want *DirError", err, err)
+			}
+			var failed []string
+			for _, e := range dirErr.Failed {
+				failed = append(failed, e.Key)
+				if !filepath.IsAbs(e.Path) || e.Err == nil {
+					t.Errorf("UploadError = %+v, want the path of the file and its error", e)
+				}
+			}
+			sort.Strings(failed)
+			if !equalStrings(failed, tt.wantFailed) {
+				t.Errorf("failed %q, want %q", failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+)
+
+// byteRange is an inclusive --content-range of a local file.
+type byteRange struct {
+	start int64
+	end   int64
+	total int64
+}
+
+// parseByteRange parses start-end/total, e.g. 1048576-2097151/4194304.
+func parseByteRange(s string) (*byteRange, error) {
+	var r byteRange
+	if _, err := fmt.Sscanf(s, "%d-%d/%d", &r.start, &r.end, &r.total); err != nil {
+		return nil, fmt.Errorf("invalid content range %q, expected start-end/total", s)
+	}
+	if r.start < 0 || r.end < r.start || r.end >= r.total {
+		return nil, fmt.Errorf("invalid content range %q", s)
+	}
+	return &r, nil
+}
+
+// length returns the number of bytes in the range.
+func (r *byteRange) length() int64 {
+	return r.end - r.start + 1
+}
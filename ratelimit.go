@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseRate parses a --limit-rate in bytes per second with an optional K, M
+// or G suffix, e.g. 5MB or 500k. The units are binary like those of
+// formatBytes.
+func parseRate(s string) (int64, error) {
+	text := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "/S")
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "B"), "I")
+	multiplier := 1.0
+	if n := len(text); n > 0 {
+		switch text[n-1:] {
+		case "K":
+			multiplier = 1 << 10
+		case "M":
+			multiplier = 1 << 20
+		case "G":
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			text = text[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, expected bytes per second such as 5MB or 500K", s)
+	}
+	return int64(value * multiplier), nil
+}
+
+// rateLimiter is a token bucket shared by every upload of a run, so their
+// total throughput stays below the rate.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allows bytesPerSecond, in bursts of at most a quarter of a
+// second worth of data.
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	rate := float64(bytesPerSecond)
+	return &rateLimiter{
+		rate:   rate,
+		burst:  rate / 4,
+		tokens: rate / 4,
+		last:   time.Now(),
+	}
+}
+
+// wait takes n bytes from the bucket, sleeping until they are available.
+// The bucket may go into debt, which the next callers wait for.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedReader reads through a rateLimiter.
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// small reads keep the bursts short
+	if max := int(r.limiter.burst); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if werr := r.limiter.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// limit wraps the body of an upload with the --limit-rate limiter, if any.
+func (u *uploader) limit(ctx context.Context, body io.Reader) io.Reader {
+	if u.limiter == nil {
+		return body
+	}
+	return &limitedReader{ctx: ctx, reader: body, limiter: u.limiter}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/cobra"
+)
+
+// replicationStatus returns the x-amz-replication-status of key, empty when
+// the object is not subject to replication.
+func replicationStatus(ctx context.Context, client *s3.Client, key string) (types.ReplicationStatus, error) {
+	var out *s3.HeadObjectOutput
+	err := withRetry(ctx, func() (err error) {
+		out, err = client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return out.ReplicationStatus, nil
+}
+
+// waitReplicated polls the replication status of key every interval until it
+// is COMPLETE. A FAILED replication, an object without replication status or
+// the end of ctx stop the wait with an error.
+func waitReplicated(ctx context.Context, client *s3.Client, key string, interval time.Duration) error {
+	for {
+		status, err := replicationStatus(ctx, client, key)
+		if err != nil {
+			return err
+		}
+		debugf("Replication status of %s is %q", key, status)
+
+		switch status {
+		case types.ReplicationStatusComplete, types.ReplicationStatusReplica:
+			return nil
+		case types.ReplicationStatusFailed:
+			return fmt.Errorf("replication of %s failed", key)
+		case "":
+			return fmt.Errorf("%s has no replication status, is replication configured for the bucket?", key)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s is still %s: %w", key, status, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+func waitReplicatedCmd() *cobra.Command {
+	wait := &cobra.Command{
+		Use:   "wait-replicated <key>",
+		Short: "wait until the replication of an object is complete",
+		Long:  "",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			interval, _ := cmd.Flags().GetDuration("interval")
+
+			key := strings.TrimLeft(args[0], "/")
+
+			sigCtx, stop := signalContext()
+			defer stop()
+
+			ctx, cancelFn := context.WithTimeout(sigCtx, timeout)
+			defer cancelFn()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			if err := waitReplicated(ctx, client, key, interval); err != nil {
+				log.Fatalln(err)
+			}
+			log.Printf("%s is replicated", key)
+		},
+	}
+
+	wait.Flags().Duration("timeout", 10*time.Minute, "Give up after this long.")
+	wait.Flags().Duration("interval", 5*time.Second, "Time between two status checks.")
+
+	return wait
+}
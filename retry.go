@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+var (
+	maxRetries = 3
+
+	// retryBaseDelay is the upper bound of the first backoff, it doubles with
+	// every retry up to retryMaxDelay
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+
+	// respectRetryAfter makes a Retry-After header of a 429 or 503 response
+	// the minimum delay of the next retry.
+	respectRetryAfter = true
+
+	// failFastOnAuthError aborts the run on the first 403 response, which no
+	// retry or later file is going to fix.
+	failFastOnAuthError = true
+
+	// skipOnAccessDenied turns a 403 from HeadObject or GetObject into a
+	// warning about that object instead of an error
+	skipOnAccessDenied = false
+)
+
+// withRetry calls fn until it succeeds, fails with an error that is not worth
+// retrying, or maxRetries retries have been made. Retries back off
+// exponentially with full jitter and stop as soon as ctx is done. With
+// --fail-fast-on-auth-error a 403 is returned as errAuth.
+//
+// fn must be safe to call again, e.g. rewind any request body it sends.
+func withRetry(ctx context.Context, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if failFastOnAuthError && !skipOnAccessDenied && isForbidden(err) {
+			return &authError{err: err}
+		}
+		if err == nil || attempt >= maxRetries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		var sleep time.Duration
+		if delay > 0 {
+			sleep = time.Duration(rand.Int63n(int64(delay)))
+		}
+		if wait, ok := retryAfter(err); ok && respectRetryAfter && wait > sleep {
+			sleep = wait
+		}
+		log.Printf("Retrying in %s (%d/%d): %s", sleep.Round(time.Millisecond), attempt+1, maxRetries, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// retryable reports whether err is transient: a 5xx or throttling response,
+// an error code such as RequestTimeout whatever its status, or a request that
+// never got a response because of the network.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// the codes first, a RequestTimeout comes with a 400
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "RequestTimeout", "InternalError":
+			return true
+		}
+	}
+
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		code := respErr.HTTPStatusCode()
+		return code >= 500 || code == 429
+	}
+	if apiErr != nil {
+		return false
+	}
+
+	var sendErr *smithyhttp.RequestSendError
+	var netErr net.Error
+	return errors.As(err, &sendErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter returns the delay requested by the Retry-After header of the
+// response err was made from, given in seconds or as an HTTP date.
+func retryAfter(err error) (time.Duration, bool) {
+	var respErr *smithyhttp.ResponseError
+	if !errors.As(err, &respErr) || respErr.Response == nil || respErr.Response.Response == nil {
+		return 0, false
+	}
+
+	v := respErr.Response.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	debugf("Ignoring invalid Retry-After header %q", v)
+	return 0, false
+}
+
+// isForbidden reports whether err is a 403 response.
+func isForbidden(err error) bool {
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == 403
+}
+
+// errAuth matches the errors withRetry returns for a 403 response with
+// --fail-fast-on-auth-error, which no retry or later file is going to fix.
+// Commands stop at the first one and exit with it once they cleaned up.
+var errAuth = errors.New("R2 rejected the credentials (HTTP 403)")
+
+// authError is a 403 response that stops the run. Its message carries the
+// hints on the usual causes.
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string {
+	return e.err.Error() + "\n" +
+		errAuth.Error() + ", check that:\n" +
+		"  - the access key ID and secret belong to an R2 API token of this account\n" +
+		"  - the token is allowed to read and write the bucket " + bucketName + "\n" +
+		"  - the token has not expired or been revoked, and the local clock is correct\n" +
+		"Aborting, use --fail-fast-on-auth-error=false to continue with the other files"
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+func (e *authError) Is(target error) bool {
+	return target == errAuth
+}
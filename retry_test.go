@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestWithRetryAuthError(t *testing.T) {
+	defer func(retries int, delay time.Duration, failFast, skipDenied bool) {
+		maxRetries, retryBaseDelay, failFastOnAuthError, skipOnAccessDenied = retries, delay, failFast, skipDenied
+	}(maxRetries, retryBaseDelay, failFastOnAuthError, skipOnAccessDenied)
+	maxRetries = 2
+	retryBaseDelay = 0
+
+	tests := []struct {
+		name       string
+		failFast   bool
+		skipDenied bool
+		wantAuth   bool
+	}{
+		{name: "fail fast", failFast: true, wantAuth: true},
+		{name: "no fail fast", failFast: false},
+		{name: "skip on access denied", failFast: true, skipDenied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failFastOnAuthError, skipOnAccessDenied = tt.failFast, tt.skipDenied
+
+			calls := 0
+			err := withRetry(context.Background(), func() error {
+				calls++
+				return statusError(403)
+			})
+			if errors.Is(err, errAuth) != tt.wantAuth {
+				t.Errorf("withRetry() = %v, errAuth %v", err, tt.wantAuth)
+			}
+			if !isForbidden(err) {
+				t.Errorf("withRetry() = %v, want the 403 response", err)
+			}
+			// a 403 is never retried
+			if calls != 1 {
+				t.Errorf("fn called %d times, want 1", calls)
+			}
+		})
+	}
+}
+
+// apiError builds the response error of an S3 error code sent with status.
+func apiError(status int, code string) error {
+	err := statusError(status).(*smithyhttp.ResponseError)
+	err.Err = &smithy.GenericAPIError{Code: code, Message: code}
+	return err
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "500", err: statusError(500), want: true},
+		{name: "502", err: statusError(502), want: true},
+		{name: "503", err: statusError(503), want: true},
+		{name: "429", err: statusError(429), want: true},
+		{name: "400", err: statusError(400)},
+		{name: "403", err: statusError(403)},
+		{name: "404", err: statusError(404)},
+		{name: "412", err: statusError(412)},
+		{name: "RequestTimeout with a 400", err: apiError(400, "RequestTimeout"), want: true},
+		{name: "SlowDown", err: apiError(503, "SlowDown"), want: true},
+		{name: "InternalError", err: apiError(500, "InternalError"), want: true},
+		{name: "Throttling without a response", err: &smithy.GenericAPIError{Code: "Throttling"}, want: true},
+		{name: "NoSuchKey", err: apiError(404, "NoSuchKey")},
+		{name: "code without a response", err: &smithy.GenericAPIError{Code: "AccessDenied"}},
+		{name: "request not sent", err: &smithyhttp.RequestSendError{Err: errors.New("connection reset")}, want: true},
+		{name: "network", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, want: true},
+		{name: "unexpected EOF", err: fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "canceled", err: &smithyhttp.RequestSendError{Err: context.Canceled}},
+		{name: "deadline", err: fmt.Errorf("send: %w", context.DeadlineExceeded)},
+		{name: "other", err: errors.New("no such file")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	withRetryAfter := func(value string) error {
+		err := statusError(503).(*smithyhttp.ResponseError)
+		err.Response.Header.Set("Retry-After", value)
+		return err
+	}
+
+	tests := []struct {
+		name   string
+		err    error
+		min    time.Duration
+		max    time.Duration
+		wantOK bool
+	}{
+		{name: "seconds", err: withRetryAfter("5"), min: 5 * time.Second, max: 5 * time.Second, wantOK: true},
+		{name: "zero", err: withRetryAfter("0"), wantOK: true},
+		// the date has no fraction of a second
+		{name: "HTTP date", err: withRetryAfter(time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)), min: 8 * time.Second, max: 10 * time.Second, wantOK: true},
+		{name: "negative", err: withRetryAfter("-1")},
+		{name: "invalid", err: withRetryAfter("soon")},
+		{name: "no header", err: statusError(503)},
+		{name: "no response", err: errors.New("timeout")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.err)
+			if ok != tt.wantOK {
+				t.Fatalf("retryAfter() = %s, %v, want ok %v", got, ok, tt.wantOK)
+			}
+			if got < tt.min || got > tt.max {
+				t.Errorf("retryAfter() = %s, want between %s and %s", got, tt.min, tt.max)
+			}
+		})
+	}
+}
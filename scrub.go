@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/cobra"
+)
+
+// remoteSHA256 downloads key and returns the hex SHA-256 of its content.
+func remoteSHA256(ctx context.Context, client *s3.Client, key string) (string, error) {
+	var sum string
+	err := withRetry(ctx, func() error {
+		out, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+		})
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, out.Body); err != nil {
+			return err
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return sum, err
+}
+
+func scrubCmd() *cobra.Command {
+	scrub := &cobra.Command{
+		Use:         "scrub <manifest-path>",
+		Annotations: map[string]string{dryRunAnnotation: "true"},
+		Short:       "check the objects of a manifest against their SHA-256 and re-upload corrupted ones",
+		Long: `scrub downloads every object listed with a sha256 in the manifest, as used by
+--require-manifest or written by --signed-manifest, and compares its content.
+Missing or corrupted objects are uploaded again from --source-dir when the
+local file still matches the manifest.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sourceDir, _ := cmd.Flags().GetString("source-dir")
+			prefix, _ := cmd.Flags().GetString("prefix")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+
+			prefix = strings.Trim(prefix, "/")
+
+			m, err := loadManifest(args[0])
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			paths := make([]string, 0, len(m))
+			for p, sum := range m {
+				if sum != "" {
+					paths = append(paths, p)
+				}
+			}
+			sort.Strings(paths)
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			u := &uploader{client: client, force: true, quiet: quiet}
+
+			var checked, corrupted, repaired int
+			var unrepaired []string
+			for _, p := range paths {
+				if ctx.Err() != nil {
+					break
+				}
+
+				key := strings.TrimPrefix(path.Join(prefix, p), "/")
+				want := m[p]
+				checked++
+
+				got, err := remoteSHA256(ctx, client, key)
+				switch {
+				case isNotFound(err):
+					log.Printf("%s is missing", key)
+				case err != nil:
+					log.Fatalln(err)
+				case got == want:
+					continue
+				default:
+					log.Printf("%s is corrupted: sha256 is %s, expected %s", key, got, want)
+				}
+				corrupted++
+
+				local := filepath.Join(sourceDir, filepath.FromSlash(p))
+				if sum, err := fileSHA256(local); err != nil || sum != want {
+					log.Printf("Warning: cannot repair %s, %s does not match the manifest", key, local)
+					unrepaired = append(unrepaired, key)
+					continue
+				}
+				if dryRun {
+					log.Printf("Would re-upload %s to %s", local, key)
+					continue
+				}
+				if err := u.uploadFile(ctx, local, key); err != nil {
+					log.Printf("Warning: re-uploading %s failed: %s", key, err)
+					unrepaired = append(unrepaired, key)
+					continue
+				}
+				repaired++
+			}
+
+			log.Printf("Checked %d objects: %d missing or corrupted, %d repaired", checked, corrupted, repaired)
+			if len(unrepaired) > 0 {
+				log.Printf("%d objects could not be repaired:", len(unrepaired))
+				for _, key := range unrepaired {
+					log.Printf("  %s", key)
+				}
+				log.Fatalln("Scrub incomplete")
+			}
+		},
+	}
+
+	scrub.Flags().String("source-dir", ".", "Directory holding the local copies of the manifest paths.")
+	scrub.Flags().String("prefix", "", "Prefix the manifest paths were uploaded below.")
+	scrub.Flags().BoolP("quiet", "q", false, "Suppress the progress of re-uploads.")
+
+	return scrub
+}
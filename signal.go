@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// abortTimeout bounds the cleanup done after an interrupt.
+const abortTimeout = 30 * time.Second
+
+// multipartUploads holds the multipart uploads that were created but not yet
+// completed or aborted, keyed by upload ID.
+var multipartUploads sync.Map
+
+type multipartUpload struct {
+	bucket string
+	key    string
+}
+
+func trackMultipartUpload(uploadID, bucket, key string) {
+	multipartUploads.Store(uploadID, multipartUpload{bucket: bucket, key: key})
+}
+
+func untrackMultipartUpload(uploadID string) {
+	multipartUploads.Delete(uploadID)
+}
+
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM, so
+// in-flight requests return instead of the process being killed mid-upload.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// exitInterrupted aborts the multipart uploads that are still open and exits.
+// Uploads that completed before the signal are left alone.
+func exitInterrupted(client *s3.Client) {
+	pending := openMultipartUploads()
+	log.Printf("Upload interrupted, cleaning up %d incomplete uploads...", len(pending))
+	abortMultipartUploads(client, pending)
+	os.Exit(1)
+}
+
+// openMultipartUploads returns the IDs of the multipart uploads that are
+// still open.
+func openMultipartUploads() []string {
+	var pending []string
+	multipartUploads.Range(func(id, _ any) bool {
+		pending = append(pending, id.(string))
+		return true
+	})
+	return pending
+}
+
+// abortMultipartUploads aborts the open multipart uploads of pending.
+func abortMultipartUploads(client *s3.Client, pending []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+
+	for _, id := range pending {
+		v, _ := multipartUploads.Load(id)
+		upload := v.(multipartUpload)
+
+		_, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(upload.bucket),
+			Key:      aws.String(upload.key),
+			UploadId: aws.String(id),
+		})
+		if err != nil {
+			log.Printf("Failed to abort the upload of %s: %s", upload.key, err)
+			continue
+		}
+		untrackMultipartUpload(id)
+	}
+}
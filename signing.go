@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// manifestPublicKey is the --public-key signed manifests must be signed
+// with, a PEM file or the PEM itself.
+var manifestPublicKey = ""
+
+// signedManifest is the file written by --signed-manifest. Signature is the
+// ASN.1 ECDSA signature of the SHA-256 of Manifest in compact JSON, made with
+// the private key of PublicKey.
+type signedManifest struct {
+	PublicKey string          `json:"publicKey"`
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature []byte          `json:"signature"`
+}
+
+// loadSigningKey reads an ECDSA P-256 private key in SEC 1 or PKCS #8 PEM.
+func loadSigningKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s holds a %s, expected an EC PRIVATE KEY", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%s is not an ECDSA P-256 key", path)
+	}
+	return ecKey, nil
+}
+
+// loadPublicKey reads an ECDSA public key in PKIX PEM from the file at value,
+// or from value itself when it holds the PEM.
+func loadPublicKey(value string) (*ecdsa.PublicKey, error) {
+	data := []byte(value)
+	name := "--public-key"
+	if !strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, err
+		}
+		name = value
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s is not a PEM PUBLIC KEY", name)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", name, err)
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ECDSA key", name)
+	}
+	return ecKey, nil
+}
+
+// trustedPublicKey returns the --public-key, or an error saying it is needed
+// to trust the signed manifest at path.
+func trustedPublicKey(path string) (*ecdsa.PublicKey, error) {
+	if manifestPublicKey == "" {
+		return nil, fmt.Errorf("%s is signed, give the key it must be signed with in --public-key", path)
+	}
+	return loadPublicKey(manifestPublicKey)
+}
+
+// recordManifest adds the uploaded file at path to the --signed-manifest.
+func (u *uploader) recordManifest(path string) {
+	if !u.signManifest {
+		return
+	}
+
+	abs, err := filepath.Abs(path)
+	if err == nil {
+		var rel string
+		if rel, err = filepath.Rel(u.root, abs); err == nil {
+			var sum string
+			if sum, err = fileSHA256(path); err == nil {
+				u.mu.Lock()
+				u.signed = append(u.signed, manifestEntry{Path: filepath.ToSlash(rel), SHA256: sum, SRI: u.sri[path]})
+				u.mu.Unlock()
+				return
+			}
+		}
+	}
+	u.handleError(path, "", fmt.Errorf("add to the signed manifest: %w", err))
+}
+
+// writeSignedManifest signs entries, in the format of --require-manifest,
+// with key and writes them to path.
+func writeSignedManifest(path string, key *ecdsa.PrivateKey, entries []manifestEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(signedManifest{
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Manifest:  data,
+		Signature: signature,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0o644)
+}
+
+// verifySignedManifest checks that a signed manifest is signed with the
+// trusted key and returns the manifest it holds. The public key embedded in
+// the file is only informative, anyone editing the file could replace it.
+func verifySignedManifest(data []byte, trusted *ecdsa.PublicKey) (json.RawMessage, error) {
+	var signed signedManifest
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, err
+	}
+
+	// the manifest is indented in the file but signed compact
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, signed.Manifest); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(compact.Bytes())
+	if !ecdsa.VerifyASN1(trusted, sum[:], signed.Signature) {
+		return nil, errors.New("the manifest is not signed with the --public-key")
+	}
+	return signed.Manifest, nil
+}
+
+// isSignedManifest tells a signed manifest, a JSON object, from a plain one,
+// a JSON array.
+func isSignedManifest(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+func verifyManifestCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify-manifest <manifest-path>",
+		Short: "verify the signature of a manifest written by --signed-manifest",
+		Long:  "",
+		Args:  cobra.ExactArgs(1),
+		// a local check, no credentials needed
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+		Run: func(cmd *cobra.Command, args []string) {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			if !isSignedManifest(data) {
+				log.Fatalf("%s is not a signed manifest", args[0])
+			}
+			key, err := trustedPublicKey(args[0])
+			if err != nil {
+				log.Fatalln(err)
+			}
+			manifest, err := verifySignedManifest(data, key)
+			if err != nil {
+				log.Fatalf("%s: %s", args[0], err)
+			}
+
+			var entries []manifestEntry
+			if err := json.Unmarshal(manifest, &entries); err != nil {
+				log.Fatalf("%s: %s", args[0], err)
+			}
+			log.Printf("%s: signature OK, %d files", args[0], len(entries))
+		},
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func publicKeyPEM(t *testing.T, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyFile, []byte(publicKeyPEM(t, &key.PublicKey)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "PEM", value: publicKeyPEM(t, &key.PublicKey)},
+		{name: "file", value: keyFile},
+		{name: "missing file", value: filepath.Join(dir, "missing.pem"), wantErr: true},
+		{name: "private key", value: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateDER})), wantErr: true},
+		{name: "not ECDSA", value: publicKeyPEM(t, edKey), wantErr: true},
+		{name: "corrupt", value: "-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadPublicKey(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadPublicKey() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !got.Equal(&key.PublicKey) {
+				t.Error("loadPublicKey() returned another key")
+			}
+		})
+	}
+}
+
+func TestVerifySignedManifest(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	entries := []manifestEntry{{Path: "b.txt", SHA256: "bb"}, {Path: "a.txt", SHA256: "aa", SRI: "sha384-x"}}
+	if err := writeSignedManifest(path, key, entries); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSignedManifest(data) {
+		t.Fatal("isSignedManifest() = false for a signed manifest")
+	}
+	tampered := bytes.Replace(data, []byte(`"bb"`), []byte(`"cc"`), 1)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		trusted *ecdsa.PublicKey
+		wantErr bool
+	}{
+		{name: "signed", data: data, trusted: &key.PublicKey},
+		{name: "other key", data: data, trusted: &other.PublicKey, wantErr: true},
+		{name: "tampered", data: tampered, trusted: &key.PublicKey, wantErr: true},
+		{name: "not JSON", data: []byte("{"), trusted: &key.PublicKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := verifySignedManifest(tt.data, tt.trusted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifySignedManifest() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []manifestEntry
+			if err := json.Unmarshal(manifest, &got); err != nil {
+				t.Fatal(err)
+			}
+			// sorted by path
+			if len(got) != 2 || got[0] != entries[0] || got[1] != entries[1] || got[0].Path != "a.txt" {
+				t.Errorf("manifest = %+v, want %+v", got, entries)
+			}
+		})
+	}
+
+	if isSignedManifest([]byte(" [{\"path\":\"a.txt\"}]")) {
+		t.Error("isSignedManifest() = true for a plain manifest")
+	}
+}
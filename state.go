@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// defaultStateFile is the --state-file of --resume when none is given
+	defaultStateFile = ".cfr2-state.json"
+	// stateSaveInterval is how often the state file is saved during a run
+	stateSaveInterval = 5 * time.Second
+)
+
+// fileState is what --state-file remembers about one uploaded file.
+type fileState struct {
+	ModTime time.Time `json:"mtime"`
+	Size    int64     `json:"size"`
+	ETag    string    `json:"etag,omitempty"`
+}
+
+// uploadState maps the absolute path of every uploaded file to its state at
+// the time of the upload.
+type uploadState map[string]fileState
+
+// loadUploadState reads a --state-file, a missing file is an empty state.
+func loadUploadState(path string) (uploadState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return uploadState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := uploadState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// save writes the state to a temporary file first, so an interrupted write
+// does not lose the previous state.
+func (s uploadState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// unmodified reports whether the file at path has the same mtime and size as
+// when it was last uploaded.
+func (s uploadState) unmodified(path string) (bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return false, err
+	}
+
+	prev, ok := s[abs]
+	return ok && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime()), nil
+}
+
+// record remembers the file at path as uploaded with the given ETag.
+func (s uploadState) record(path string, info fs.FileInfo, etag string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	s[abs] = fileState{ModTime: info.ModTime(), Size: info.Size(), ETag: etag}
+}
+
+// checkpoint saves the --state-file when the last save is stateSaveInterval
+// ago, or always with force. The caller holds u.mu.
+func (u *uploader) checkpoint(force bool) {
+	if u.state == nil || u.stateFile == "" || u.dryRun {
+		return
+	}
+	if !force && time.Since(u.stateSaved) < stateSaveInterval {
+		return
+	}
+	if err := u.state.save(u.stateFile); err != nil {
+		log.Println(err)
+		return
+	}
+	u.stateSaved = time.Now()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/cuipeiyu/cloudflare-r2-uploader/r2"
+)
+
+// stdinPath is the local path that uploads standard input.
+const stdinPath = "-"
+
+// uploadStream uploads everything read from r as key. The size is not known
+// up front: input that fits in one part is sent with a single PutObject,
+// anything longer as a multipart upload of --part-size parts, at most
+// --part-concurrency of them buffered in memory. With compress the input is
+// gzipped on the way and, unless key ends in .gz, gets Content-Encoding gzip.
+func (u *uploader) uploadStream(ctx context.Context, r io.Reader, key string, compress bool) error {
+	headers := u.headersFor(key, key)
+	if compress {
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			gz := gzip.NewWriter(pw)
+			_, err := io.Copy(gz, r)
+			if err == nil {
+				err = gz.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		r = pr
+
+		if !strings.HasSuffix(key, ".gz") {
+			// an explicit --content-encoding still wins
+			headers = objectHeaders{ContentEncoding: "gzip"}.merge(headers)
+		}
+	}
+
+	first := make([]byte, u.partSize)
+	n, err := io.ReadFull(r, first)
+	short := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !short {
+		return err
+	}
+	first = first[:n]
+
+	// the sniffed content of compressed input would only tell it is gzip
+	var head func() []byte
+	if !compress {
+		head = func() []byte { return first }
+	}
+	mimeType := u.contentType(key, head)
+
+	u.logf("Uploading standard input to %s as %s", key, mimeType)
+	if u.events != nil {
+		u.events.emit(progressEvent{Event: "start", Path: stdinPath, Key: key})
+	}
+
+	var size int64
+	if short {
+		size = int64(n)
+		err = withRetry(ctx, func() error {
+			input := &s3.PutObjectInput{
+				Bucket:        aws.String(bucketName),
+				Key:           aws.String(key),
+				RequestPayer:  requestPayer(),
+				Body:          u.limit(ctx, bytes.NewReader(first)),
+				ContentType:   aws.String(mimeType),
+				ContentLength: size,
+			}
+			headers.apply(input)
+
+			_, err := u.client.PutObject(ctx, input, u.putOptions...)
+			return err
+		})
+	} else {
+		_, err = u.multipartUpload(ctx, key, mimeType, headers, func(uploadID string) ([]types.CompletedPart, error) {
+			parts, sent, err := u.streamParts(ctx, io.MultiReader(bytes.NewReader(first), r), key, uploadID)
+			size = sent
+			return parts, err
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := u.tagObject(ctx, key); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.uploaded++
+	u.bytes += size
+	if u.events != nil {
+		u.events.emit(progressEvent{Event: "done", Path: stdinPath, Key: key, Bytes: size, Total: size})
+	}
+	return nil
+}
+
+// streamParts reads r in parts of --part-size and sends them as the parts of
+// the multipart upload, --part-concurrency at a time. It returns the parts
+// in order and the number of bytes sent.
+func (u *uploader) streamParts(ctx context.Context, r io.Reader, key, uploadID string) ([]types.CompletedPart, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    []types.CompletedPart
+		sent     int64
+		firstErr error
+	)
+	sem := make(chan struct{}, u.partConcurrency)
+	client, options := u.r2Client(), u.partOptions()
+
+	// fail keeps the first error and cancels the parts in flight
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for number := int32(1); ctx.Err() == nil; number++ {
+		// wait for a free slot before buffering the next part
+		sem <- struct{}{}
+
+		buf := make([]byte, u.partSize)
+		n, err := io.ReadFull(r, buf)
+		if errors.Is(err, io.EOF) {
+			<-sem
+			break
+		}
+		last := errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			<-sem
+			fail(err)
+			break
+		}
+		if number > r2.MaxParts {
+			<-sem
+			fail(fmt.Errorf("standard input is longer than %d parts of %d bytes, raise --part-size", r2.MaxParts, u.partSize))
+			break
+		}
+
+		mu.Lock()
+		parts = append(parts, types.CompletedPart{PartNumber: number})
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(number int32, data []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			etag, err := client.UploadPart(ctx, bytes.NewReader(data), key, uploadID, number, 0, int64(len(data)), options, nil)
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			parts[number-1].ETag = aws.String(etag)
+			sent += int64(len(data))
+			u.logf("Sent part %d of %s, %s so far", number, key, formatBytes(sent))
+		}(number, buf[:n])
+
+		if last {
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return parts, sent, firstErr
+}
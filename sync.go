@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cuipeiyu/cloudflare-r2-uploader/r2"
+	"github.com/spf13/cobra"
+)
+
+// mtimeMetadata formats a modification time for the mtime metadata, as read
+// back by objectMtime.
+func mtimeMetadata(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// compareSync decides about the file at path like decide, from the HeadObject
+// of key: a different size is a change, the same size and mtime metadata is
+// not. Otherwise the local MD5 or multipart ETag is compared with the ETag.
+func (u *uploader) compareSync(ctx context.Context, path, key string) (bool, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, "", err
+	}
+
+	var head *s3.HeadObjectOutput
+	err = withRetry(ctx, func() (err error) {
+		head, err = u.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+		})
+		return err
+	})
+	if isNotFound(err) {
+		return false, "new", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	if head.ContentLength != info.Size() {
+		return false, "changed", nil
+	}
+	if head.Metadata["mtime"] == mtimeMetadata(info.ModTime()) {
+		return true, "unchanged", nil
+	}
+
+	remote := strings.Trim(aws.ToString(head.ETag), `"`)
+	local, err := u.localETag(path, info.Size(), remote)
+	if err != nil {
+		return false, "", err
+	}
+	if local == remote {
+		return true, "unchanged", nil
+	}
+	return false, "changed", nil
+}
+
+// localETag returns the ETag the file at path gets when uploaded the way the
+// remote ETag was: the hex MD5 of the content, or for "<md5>-<parts>" the MD5
+// of the part MD5s followed by the number of parts. Parts are assumed to be
+// of r2.PartSize, an object uploaded in other parts always looks changed.
+func (u *uploader) localETag(path string, size int64, remote string) (string, error) {
+	_, partsText, multipart := strings.Cut(remote, "-")
+	if !multipart {
+		sum, err := fileMD5(path)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(sum), nil
+	}
+
+	parts, err := strconv.Atoi(partsText)
+	if err != nil {
+		return "", nil
+	}
+	partSize := r2.PartSize(size, u.partSize)
+	if int64(parts) != (size+partSize-1)/partSize {
+		return "", nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sums := md5.New()
+	for offset := int64(0); offset < size; offset += partSize {
+		h := md5.New()
+		if _, err := io.Copy(h, io.NewSectionReader(file, offset, partSize)); err != nil {
+			return "", err
+		}
+		sums.Write(h.Sum(nil))
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), parts), nil
+}
+
+func syncCmd() *cobra.Command {
+	sync := &cobra.Command{
+		Use:         "sync <local-path> <remote-path>",
+		Annotations: map[string]string{dryRunAnnotation: "true"},
+		Short:       "upload the files that differ from their objects",
+		Long:        "",
+		Args:        cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			deleteRemoved, _ := cmd.Flags().GetBool("delete")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			multipartThreshold, _ := cmd.Flags().GetInt64("multipart-threshold")
+			noMultipartForText, _ := cmd.Flags().GetBool("no-multipart-for-text")
+			keepFailedMultipart := keepFailedMultipartFlag(cmd)
+			partSize := partSizeFlag(cmd)
+			partConcurrency, _ := cmd.Flags().GetInt("part-concurrency")
+			followSymlinks, _ := cmd.Flags().GetBool("follow-symlinks")
+
+			if parallel < 1 {
+				log.Fatalln("--parallel must be at least 1")
+			}
+			if partSize < r2.MinPartSize {
+				log.Fatalf("--part-size must be at least %d bytes (--part-size-mb 5)", r2.MinPartSize)
+			}
+			if partConcurrency < 1 {
+				log.Fatalln("--part-concurrency must be at least 1")
+			}
+
+			localPath := args[0]
+			remotePath := strings.TrimLeft(args[1], "/")
+
+			info, err := os.Stat(localPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if deleteRemoved && !info.IsDir() {
+				log.Fatalln("--delete needs a local directory")
+			}
+
+			sigCtx, stop := signalContext()
+			defer stop()
+
+			// cancelled by the errors of stopsRun, which let the run end
+			// normally
+			ctx, cancelRun := context.WithCancel(sigCtx)
+			defer cancelRun()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			u := &uploader{
+				client:              client,
+				parallel:            parallel,
+				force:               true,
+				sync:                true,
+				quiet:               quiet,
+				dryRun:              dryRun,
+				readBufferSize:      256 << 10,
+				multipartThreshold:  multipartThreshold,
+				noMultipartForText:  noMultipartForText,
+				keepFailedMultipart: keepFailedMultipart,
+				partSize:            partSize,
+				partConcurrency:     partConcurrency,
+				skipUnsupported:     true,
+			}
+			u.root, _ = filepath.Abs(localPath)
+			if !info.IsDir() {
+				u.root = filepath.Dir(u.root)
+			}
+
+			uploadOne := func(path, key string) {
+				err := u.uploadFile(ctx, path, key)
+				switch {
+				case stopsRun(err):
+					u.stop(err, cancelRun)
+				case err != nil && ctx.Err() == nil:
+					u.handleError(path, key, err)
+				}
+			}
+
+			start := time.Now()
+
+			// the keys of the local files, the objects --delete keeps
+			keys := map[string]bool{}
+			if info.IsDir() {
+				pool := newUploadPool(u.parallel, uploadOne)
+				walkFiles(u.root, followSymlinks, u.skipSpecial, func(path string, info fs.FileInfo, err error) error {
+					if ctx.Err() != nil {
+						return ctx.Err() // stop walking
+					}
+					if err != nil {
+						u.handleError(path, "", err)
+						return nil
+					}
+					if info.IsDir() {
+						return nil
+					}
+					if u.unsupported(info) {
+						u.skipSpecial(path, "it is not a regular file ("+info.Mode().String()+")")
+						return nil
+					}
+
+					key := strings.TrimPrefix(path, u.root)
+					key = strings.TrimPrefix(filepath.Join(remotePath, key), "/")
+					keys[key] = true
+					pool.add(path, key)
+					return nil
+				})
+				pool.wait()
+			} else {
+				uploadOne(localPath, remotePath)
+			}
+
+			if sigCtx.Err() != nil {
+				exitInterrupted(client)
+			}
+
+			// the uploads a stopped run cancelled abort with their context,
+			// the ones that could not are still open
+			if u.stopped != nil {
+				abortMultipartUploads(client, openMultipartUploads())
+			}
+
+			if deleteRemoved && u.stopped == nil {
+				prefix := remotePath
+				if prefix != "" && !strings.HasSuffix(prefix, "/") {
+					prefix += "/"
+				}
+				if err := u.deleteUnwanted(ctx, prefix, keys, "without a local file"); err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			if dryRun {
+				if err := u.printPlan("text"); err != nil {
+					log.Fatalln(err)
+				}
+				if u.stopped != nil {
+					log.Fatalln(u.stopped)
+				}
+				return
+			}
+
+			summary := template.Must(template.New("summary").Parse(defaultSummaryFormat))
+			if err := u.printSummary(summary, time.Since(start)); err != nil {
+				log.Println(err)
+			}
+			if len(u.skippedSpecial) > 0 {
+				u.printSkippedSpecial()
+			}
+			if u.stopped != nil {
+				log.Fatalln(u.stopped)
+			}
+			log.Println("Sync complete.")
+		},
+	}
+
+	sync.Flags().Bool("delete", false, "Delete the objects below the remote path that have no local file any more.")
+	sync.Flags().BoolP("quiet", "q", false, "Suppress per-file progress and only print the final summary.")
+	sync.Flags().Int("parallel", 4, "Number of files uploaded at the same time. Per-file progress is only shown with 1, parallel uploads print a line when each file starts and finishes.")
+	sync.Flags().Int64("multipart-threshold", 100<<20, "Upload files of at least this many bytes as multipart uploads, 0 always uses a single PUT.")
+	sync.Flags().Bool("no-multipart-for-text", false, "Upload files with a text/* content type with a single PUT up to the 5 GiB limit, whatever their size, so their ETag is the MD5 of their content.")
+	sync.Flags().Int64("part-size", 16<<20, "Size in bytes of the parts of a multipart upload, at least 5 MiB. Multipart ETags are only compared for objects uploaded with the same part size.")
+	sync.Flags().Int64("part-size-mb", 16, "--part-size in MiB, e.g. 100 for 104857600 bytes.")
+	sync.MarkFlagsMutuallyExclusive("part-size", "part-size-mb")
+	sync.Flags().Bool("abort-multipart-on-error", true, "Abort a multipart upload whose parts could not be sent, so they do not take up storage.")
+	sync.Flags().Bool("no-abort-multipart-on-error", false, "Keep the parts of a failed multipart upload in the bucket for inspection, its upload ID is printed. Interrupted uploads are still aborted.")
+	sync.MarkFlagsMutuallyExclusive("abort-multipart-on-error", "no-abort-multipart-on-error")
+	sync.Flags().Int("part-concurrency", 4, "Number of parts of one file uploaded at the same time, on top of --parallel.")
+	sync.Flags().Bool("follow-symlinks", false, "Upload the targets of symlinks and walk symlinked directories, instead of skipping symlinks with a warning. Links back to a directory being synced are skipped.")
+
+	return sync
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cuipeiyu/cloudflare-r2-uploader/r2"
+)
+
+func TestLocalETag(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), r2.MinPartSize/10+1)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	whole := md5.Sum(data)
+	first := md5.Sum(data[:r2.MinPartSize])
+	second := md5.Sum(data[r2.MinPartSize:])
+	parts := md5.Sum(append(first[:], second[:]...))
+	multipart := fmt.Sprintf("%s-2", hex.EncodeToString(parts[:]))
+
+	tests := []struct {
+		name   string
+		remote string
+		want   string
+	}{
+		{name: "single part", remote: "d41d8cd98f00b204e9800998ecf8427e", want: hex.EncodeToString(whole[:])},
+		{name: "two parts", remote: "0123-2", want: multipart},
+		// parts of another size than --part-size can not be matched
+		{name: "other part count", remote: "0123-3", want: ""},
+		{name: "invalid part count", remote: "0123-x", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &uploader{partSize: r2.MinPartSize}
+			got, err := u.localETag(path, int64(len(data)), tt.remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("localETag(%q) = %q, want %q", tt.remote, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// maxTags is the number of tags an object can have.
+	maxTags = 10
+	// maxTagKeyLength and maxTagValueLength are counted in characters.
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// parseTagJSON converts a JSON object of strings such as
+//
+//	{"env": "prod", "team": "frontend"}
+//
+// into object tags, sorted by key.
+func parseTagJSON(s string) ([]types.Tag, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(s), &fields); err != nil {
+		return nil, fmt.Errorf("invalid tag JSON: %w", err)
+	}
+	if len(fields) > maxTags {
+		return nil, fmt.Errorf("%d tags given, an object can have at most %d", len(fields), maxTags)
+	}
+
+	tags := make([]types.Tag, 0, len(fields))
+	for k, v := range fields {
+		if k == "" || utf8.RuneCountInString(k) > maxTagKeyLength {
+			return nil, fmt.Errorf("tag key %q must be 1 to %d characters", k, maxTagKeyLength)
+		}
+		if utf8.RuneCountInString(v) > maxTagValueLength {
+			return nil, fmt.Errorf("value of tag %q is longer than %d characters", k, maxTagValueLength)
+		}
+		tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return aws.ToString(tags[i].Key) < aws.ToString(tags[j].Key)
+	})
+	return tags, nil
+}
+
+// tagObject sets the --tag-json tags on the uploaded key.
+func (u *uploader) tagObject(ctx context.Context, key string) error {
+	if len(u.tags) == 0 {
+		return nil
+	}
+
+	return withRetry(ctx, func() error {
+		_, err := u.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			Tagging:      &types.Tagging{TagSet: u.tags},
+			RequestPayer: requestPayer(),
+		})
+		return err
+	})
+}
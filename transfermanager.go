@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newTransferManager returns the upload manager of --enable-transfer-manager,
+// sending parts of partSize concurrency at a time.
+func newTransferManager(client *s3.Client, partSize int64, concurrency int, keepFailed bool) *manager.Uploader {
+	return manager.NewUploader(client, func(m *manager.Uploader) {
+		m.PartSize = partSize
+		m.Concurrency = concurrency
+		m.LeavePartsOnError = keepFailed
+	})
+}
+
+// newDownloadManager returns the download manager of --download-manager,
+// fetching ranges of partSize concurrency at a time.
+func newDownloadManager(client *s3.Client, partSize int64, concurrency int) *manager.Downloader {
+	return manager.NewDownloader(client, func(m *manager.Downloader) {
+		m.PartSize = partSize
+		m.Concurrency = concurrency
+	})
+}
+
+// managerUpload sends length bytes of file from offset as key with the upload
+// manager of the SDK, which decides between a single PutObject and a
+// multipart upload by its part size and aborts a failed multipart upload.
+// It returns the ETag of the object.
+func (u *uploader) managerUpload(ctx context.Context, file *os.File, key string, offset, length int64, mimeType string, headers objectHeaders, sri hash.Hash, progress func(int64, int64)) (string, error) {
+	var etag string
+	err := withRetry(ctx, func() error {
+		var body io.Reader = io.NewSectionReader(file, offset, length)
+		if u.readBufferSize > 0 {
+			body = bufio.NewReaderSize(body, u.readBufferSize)
+		}
+		if sri != nil {
+			// the manager reads the body in order, also for multipart uploads
+			sri.Reset()
+			body = io.TeeReader(body, sri)
+		}
+
+		input := &s3.PutObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+			Body:         NewProgressReader(u.limit(ctx, body), length, progress),
+			ContentType:  aws.String(mimeType),
+		}
+		headers.apply(input)
+
+		out, err := u.transferManager.Upload(ctx, input)
+		if err != nil {
+			// the manager aborts with ctx, which is done after an
+			// interrupt, leave the upload to exitInterrupted
+			var failure manager.MultiUploadFailure
+			if errors.As(err, &failure) && ctx.Err() != nil {
+				trackMultipartUpload(failure.UploadID(), bucketName, key)
+			}
+			return err
+		}
+		etag = aws.ToString(out.ETag)
+		return nil
+	})
+	return etag, err
+}
+
+// managerDownload writes key to path with the download manager of the SDK,
+// which fetches ranges of the object concurrently and writes each at its
+// offset. path is the temporary file of downloadObject, which only renames
+// it onto the destination once the download is complete. It returns the
+// size and modification time of the object.
+func (d *downloader) managerDownload(ctx context.Context, key, path string) (int64, time.Time, error) {
+	// the size for the progress and the metadata for --restore-mtime, the
+	// download manager returns neither
+	var head *s3.HeadObjectOutput
+	err := withRetry(ctx, func() (err error) {
+		head, err = d.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+		})
+		return err
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var progress func(int64, int64)
+	if !d.quiet {
+		progress = newProgressPrinter(key).update
+	}
+
+	// never created here, so nothing but the temporary file is written
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	// a retry writes every range again at its offset
+	var size int64
+	err = withRetry(ctx, func() (err error) {
+		size, err = d.transferManager.Download(ctx, &progressWriterAt{writer: file, total: head.ContentLength, progress: progress}, &s3.GetObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+			// every range must come from the object HeadObject saw
+			IfMatch: head.ETag,
+		})
+		return err
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return size, objectMtime(head.Metadata, head.LastModified), err
+}
+
+// progressWriterAt reports the bytes written through it, which the download
+// manager writes from several goroutines in any order.
+type progressWriterAt struct {
+	writer   io.WriterAt
+	total    int64
+	progress func(int64, int64)
+
+	mu      sync.Mutex
+	written int64
+}
+
+func (w *progressWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.writer.WriteAt(p, off)
+	if w.progress != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.written += int64(n)
+		w.progress(w.written, w.total)
+	}
+	return n, err
+}
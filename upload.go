@@ -0,0 +1,966 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// plannedUpload is one line of the --dry-run report. Action is upload, overwrite
+// or skip.
+type plannedUpload struct {
+	Path        string `json:"path"`
+	Key         string `json:"key"`
+	Action      string `json:"action"`
+	Reason      string `json:"reason,omitempty"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// uploadFailure is one entry of the failure report and of --error-log.
+type uploadFailure struct {
+	Path  string `json:"path"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// defaultSummaryFormat is the --upload-summary-format template of the final
+// summary line.
+const defaultSummaryFormat = "Uploaded {{.Uploaded}} files, skipped {{.Skipped}} files{{if .Empty}}, skipped (empty) {{.Empty}} files{{end}}{{if .Failed}}, failed {{.Failed}} files{{end}}"
+
+// uploadSummary holds the values available to --upload-summary-format.
+type uploadSummary struct {
+	Uploaded int
+	Skipped  int
+	// Empty is the number of empty files left out by --skip-zero-byte
+	Empty    int
+	Failed   int
+	Bytes    int64
+	Duration time.Duration
+	// Speed is the average upload speed in bytes per second
+	Speed float64
+}
+
+// uploader uploads local files to the configured bucket and keeps track of
+// what it did for the final summary.
+type uploader struct {
+	client *s3.Client
+
+	// parallel is the number of files uploaded at the same time, mu guards
+	// the counters, reports and indexes shared between them
+	parallel int
+	mu       sync.Mutex
+
+	force        bool
+	quiet        bool
+	dryRun       bool
+	headers      objectHeaders
+	tags         []types.Tag
+	cacheControl []cacheControlRule
+	rules        headerRules
+	charset      string
+	compact      *compactProgress
+	events       *progressEvents
+
+	// contentTypeOverride is the --content-type of every object
+	contentTypeOverride string
+
+	// xattrMetadata adds the user.* extended attributes of each file
+	xattrMetadata bool
+	// aclFromMode picks the ACL of each file from its permissions
+	aclFromMode bool
+	// pathACLs picks the ACL of each object from the directories of its key
+	pathACLs pathACLs
+
+	// mtimeMap picks the Cache-Control max-age from the age of each file
+	mtimeMap []mtimeMaxAge
+
+	// sri maps the uploaded files to their --generate-sri integrity value
+	sri map[string]string
+
+	// readBufferSize is the size of the buffer files are read through
+	readBufferSize int
+
+	// limiter holds the bodies of all uploads to --limit-rate
+	limiter *rateLimiter
+
+	// transferManager sends the files instead of PutObject and the
+	// multipart code with --enable-transfer-manager
+	transferManager *manager.Uploader
+
+	// contentRange limits the upload to a part of the file
+	contentRange *byteRange
+
+	// files of at least multipartThreshold bytes are uploaded in parts of
+	// partSize, partConcurrency at a time
+	multipartThreshold int64
+	partSize           int64
+	partConcurrency    int
+	// noMultipartForText sends text files with a single PutObject up to
+	// maxPutSize, whatever multipartThreshold says
+	noMultipartForText bool
+	// keepFailedMultipart leaves the parts of failed multipart uploads in
+	// the bucket, --no-abort-multipart-on-error
+	keepFailedMultipart bool
+
+	// maxKeyLength is the longest key in bytes that is sent to R2
+	maxKeyLength int
+
+	hashCompareRemote bool
+	contentMD5        bool
+	contentSHA256     bool
+	noClobber         bool
+	// stopped is the first error that stops the run, see stopsRun
+	stopped error
+
+	// checksumMetadata stores the SHA-256 of every file as metadata
+	checksumMetadata bool
+
+	// sync compares files with their objects like the sync command and
+	// stores their mtime as metadata
+	sync bool
+
+	// dedup is the --dedup-by-hash index of the bucket content
+	dedup     etagIndex
+	dedupCopy bool
+
+	// putOptions are applied to every PutObject and CompleteMultipartUpload
+	// call
+	putOptions []func(*s3.Options)
+	// runHeaders is the --signed-header-secret option, applied to the parts
+	// of multipart uploads as well
+	runHeaders func(*s3.Options)
+
+	// keyMap overrides the derived key of the files it lists
+	keyMap keyMap
+
+	// keyRewrites are applied in order to every computed key
+	keyRewrites []keyRewrite
+
+	// spaceEncoding is the --key-encode-spaces mode: none, pct or plus
+	spaceEncoding string
+	encodeUnicode bool
+
+	// dateSuffix is the formatted --key-date-suffix, added to every key
+	dateSuffix         string
+	dateSuffixAfterExt bool
+
+	// namespace and its separator are prepended to every key last
+	namespace          string
+	namespaceSeparator string
+
+	// state is the --state-file of --upload-modified-only, saved to
+	// stateFile while uploading
+	state      uploadState
+	stateFile  string
+	stateSaved time.Time
+
+	// root is the directory that relative paths are computed against
+	root     string
+	manifest manifest
+
+	// signed collects the uploaded files for --signed-manifest
+	signManifest bool
+	signed       []manifestEntry
+
+	skipDotFiles    bool
+	skipUnsupported bool
+	skipZeroByte    bool
+	// skippedSpecial are the symlinks and special files left out of a
+	// directory upload
+	skippedSpecial []string
+
+	// filters are the --include and --exclude rules in the order given
+	filters pathRules
+	// ignores holds the rules of the ignore files of every directory read
+	// so far with --respect-ignore-files
+	respectIgnoreFiles bool
+	ignores            map[string]pathRules
+
+	ignoreErrors    bool
+	continueOnError bool
+	skipUnreadable  bool
+	maxErrors       int
+
+	uploaded int
+	skipped  int
+	failed   int
+
+	skippedEmpty int
+	bytes        int64
+	plan         []plannedUpload
+	failures     []uploadFailure
+	unreadable   []string
+}
+
+// excluded reports whether the file or directory at path below u.root is left
+// out of the upload. The root itself is never excluded. --include and
+// --exclude only apply to files, a later --include may pick a file from an
+// excluded directory.
+func (u *uploader) excluded(path string, info fs.FileInfo) bool {
+	if path == u.root {
+		u.loadIgnoreFiles(path)
+		return false
+	}
+	if u.skipDotFiles && strings.HasPrefix(info.Name(), ".") {
+		return true
+	}
+	if u.respectIgnoreFiles && (u.ignored(path, info.IsDir()) || info.Name() == ".r2ignore") {
+		return true
+	}
+
+	if info.IsDir() {
+		u.loadIgnoreFiles(path)
+		return false
+	}
+	rel, err := filepath.Rel(u.root, path)
+	if err != nil {
+		return false
+	}
+	include, ok := u.filters.match(filepath.ToSlash(rel), false)
+	return ok && !include
+}
+
+// unsupported reports whether info is a device, socket, pipe or other special
+// file that --skip-unsupported-files leaves out. Symlinks are handled by the
+// walk, see --follow-symlinks.
+func (u *uploader) unsupported(info fs.FileInfo) bool {
+	const special = fs.ModeDevice | fs.ModeCharDevice | fs.ModeNamedPipe | fs.ModeSocket | fs.ModeIrregular
+	return u.skipUnsupported && info.Mode()&special != 0
+}
+
+// empty reports whether info is an empty regular file that --skip-zero-byte
+// leaves out.
+func (u *uploader) empty(info fs.FileInfo) bool {
+	return u.skipZeroByte && info.Mode().IsRegular() && info.Size() == 0
+}
+
+// modeACL maps the permissions of a file to a canned ACL: public-read when
+// everyone may read it, private otherwise.
+func modeACL(mode fs.FileMode) string {
+	if mode.Perm()&0o004 != 0 {
+		return string(types.ObjectCannedACLPublicRead)
+	}
+	return string(types.ObjectCannedACLPrivate)
+}
+
+// isHTML reports whether path is an HTML page, held back by --upload-in-order.
+func isHTML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".html" || ext == ".htm"
+}
+
+// keyRewrite is one --key-regex-replace rule.
+type keyRewrite struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// parseKeyRewrites compiles repeated pattern=replacement flags. The pattern
+// ends at the first "=", the replacement may use $1 style references.
+func parseKeyRewrites(values []string) ([]keyRewrite, error) {
+	rewrites := make([]keyRewrite, 0, len(values))
+	for _, v := range values {
+		pattern, replacement, ok := strings.Cut(v, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid key rewrite %q, expected pattern=replacement", v)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key rewrite pattern %q: %w", pattern, err)
+		}
+		rewrites = append(rewrites, keyRewrite{re: re, replacement: replacement})
+	}
+	return rewrites, nil
+}
+
+// rewriteKey applies the --key-regex-replace rules to key.
+func (u *uploader) rewriteKey(key string) string {
+	for _, r := range u.keyRewrites {
+		key = r.re.ReplaceAllString(key, r.replacement)
+	}
+	return key
+}
+
+// dateSuffixed adds the --key-date-suffix to key, before or after its
+// extension: index.html becomes index-20240115.html or index.html-20240115.
+func (u *uploader) dateSuffixed(key string) string {
+	if u.dateSuffix == "" {
+		return key
+	}
+	ext := path.Ext(key)
+	// a dot file such as .env has no extension to put the date before
+	if u.dateSuffixAfterExt || ext == "" || ext == path.Base(key) {
+		return key + "-" + u.dateSuffix
+	}
+	return strings.TrimSuffix(key, ext) + "-" + u.dateSuffix + ext
+}
+
+// namespaced prepends the --object-namespace to key.
+func (u *uploader) namespaced(key string) string {
+	if u.namespace == "" {
+		return key
+	}
+	return u.namespace + u.namespaceSeparator + key
+}
+
+// exists reports whether key is present in the bucket. Only a 404 means it
+// is not, any other failure of HeadObject is returned instead of being taken
+// for an existing object. With --skip-on-access-denied a 403 counts as
+// present, so the object is left alone.
+func (u *uploader) exists(ctx context.Context, key string) (bool, error) {
+	err := withRetry(ctx, func() error {
+		_, err := u.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+		})
+		return err
+	})
+	switch {
+	case err == nil:
+		return true, nil
+	case isNotFound(err):
+		return false, nil
+	case skipOnAccessDenied && isForbidden(err):
+		log.Printf("Warning: access to \"%s\" denied, leaving it alone", key)
+		return true, nil
+	default:
+		return false, fmt.Errorf("checking whether %s exists: %w", key, err)
+	}
+}
+
+// decide returns whether the file at path should be skipped, and why it is
+// skipped or uploaded: "unmodified", "exists" and "unchanged" for skipped
+// files, "new", "changed" and "force" for uploaded ones.
+func (u *uploader) decide(ctx context.Context, path, key string) (bool, string, error) {
+	if u.state != nil {
+		u.mu.Lock()
+		unmodified, err := u.state.unmodified(path)
+		u.mu.Unlock()
+		if err != nil {
+			return false, "", err
+		}
+		if unmodified {
+			return true, "unmodified", nil
+		}
+	}
+
+	switch {
+	case u.sync:
+		return u.compareSync(ctx, path, key)
+	case u.hashCompareRemote:
+		found, same, err := u.compareRemote(ctx, path, key)
+		switch {
+		case err != nil:
+			return false, "", err
+		case !found:
+			return false, "new", nil
+		case same:
+			return true, "unchanged", nil
+		default:
+			return false, "changed", nil
+		}
+	case !u.force:
+		exists, err := u.exists(ctx, key)
+		switch {
+		case err != nil:
+			return false, "", err
+		case exists:
+			return true, "exists", nil
+		default:
+			return false, "new", nil
+		}
+	default:
+		return false, "force", nil
+	}
+}
+
+// compareRemote downloads key and compares the SHA-256 of its content with the
+// local file. This also works for objects whose ETag is not an MD5.
+func (u *uploader) compareRemote(ctx context.Context, path, key string) (found, same bool, err error) {
+	var remote string
+	err = withRetry(ctx, func() error {
+		out, err := u.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+		})
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, out.Body); err != nil {
+			return err
+		}
+		remote = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if isNotFound(err) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	local, err := fileSHA256(path)
+	if err != nil {
+		return true, false, err
+	}
+	return true, local == remote, nil
+}
+
+// errClobber is returned by uploadFile for an object that already exists with
+// --no-clobber. It fails the run once the files in flight are done.
+var errClobber = errors.New("refusing to overwrite it (--no-clobber)")
+
+// stopsRun reports whether err stops the whole run instead of failing one
+// file: errClobber or errAuth. The files in flight are cancelled and the
+// run exits with err after its summary.
+func stopsRun(err error) bool {
+	return errors.Is(err, errClobber) || errors.Is(err, errAuth)
+}
+
+// stop records err as the error that stops the run, unless an earlier one
+// did, and cancels the uploads in flight with cancel.
+func (u *uploader) stop(err error, cancel context.CancelFunc) {
+	u.mu.Lock()
+	if u.stopped == nil {
+		u.stopped = err
+	}
+	u.mu.Unlock()
+	cancel()
+}
+
+// uploadFile uploads the local file at path as key, unless decide says it
+// should be skipped.
+func (u *uploader) uploadFile(ctx context.Context, path, key string) error {
+	if err := u.checkKey(path, key); err != nil {
+		return err
+	}
+
+	if u.manifest != nil {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(u.root, abs)
+		if err != nil {
+			return err
+		}
+		if err := u.manifest.verify(filepath.ToSlash(rel), path); err != nil {
+			return err
+		}
+	}
+
+	// an existing object is a deployment problem, not something to skip
+	if u.noClobber {
+		exists, err := u.exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("\"%s\" already exists in the bucket, %w", key, errClobber)
+		}
+	}
+
+	if u.dedup != nil {
+		src, err := u.duplicateOf(path)
+		if err != nil {
+			return err
+		}
+		if src != "" {
+			return u.dedupFile(ctx, path, key, src)
+		}
+	}
+
+	skip, reason, err := u.decide(ctx, path, key)
+	if err != nil {
+		return err
+	}
+
+	if u.dryRun {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		return u.planFile(ctx, path, key, skip, reason, info.Size())
+	}
+
+	if skip {
+		u.logf("\"%s\" is %s will be skipped", key, reason)
+		if u.events != nil {
+			u.events.emit(progressEvent{Event: "skip", Path: path, Key: key, Reason: reason})
+		}
+
+		if u.compact != nil {
+			if info, err := os.Stat(path); err == nil {
+				u.compact.skip(info.Size())
+			}
+		}
+
+		u.mu.Lock()
+		u.skipped++
+		u.mu.Unlock()
+		return nil
+	}
+
+	mimeType := u.contentType(path, fileHead(path))
+
+	u.mu.Lock()
+	n := u.uploaded
+	u.mu.Unlock()
+	u.logf("Uploading [% 4d] %s as %s", n, key, mimeType)
+	started := time.Now()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset, length := int64(0), fileInfo.Size()
+	if r := u.contentRange; r != nil {
+		if r.total != fileInfo.Size() {
+			return fmt.Errorf("content range total %d does not match the size of %s, %d bytes", r.total, path, fileInfo.Size())
+		}
+		offset, length = r.start, r.length()
+	}
+
+	var progress func(int64, int64)
+	switch {
+	case u.events != nil:
+		u.events.emit(progressEvent{Event: "start", Path: path, Key: key, Total: length})
+		progress = u.events.reader(path, key)
+	case u.compact != nil:
+		progress = u.compact.reader(key)
+	case !u.quiet && u.parallel <= 1:
+		// the progress lines of parallel uploads would overwrite each other,
+		// they get a line when they finish instead
+		progress = newProgressPrinter(key).update
+	}
+
+	headers := u.headersFor(path, key)
+	if u.xattrMetadata {
+		metadata, err := xattrMetadata(path)
+		if err != nil {
+			return err
+		}
+		// metadata given on the command line wins
+		headers = objectHeaders{Metadata: metadata}.merge(headers)
+	}
+	if u.mtimeMap != nil {
+		// an explicit --cache-control still wins
+		headers = objectHeaders{CacheControl: mtimeCacheControl(u.mtimeMap, fileInfo.ModTime())}.merge(headers)
+	}
+	if u.aclFromMode {
+		// a header rule setting the ACL still wins
+		headers = objectHeaders{ACL: modeACL(fileInfo.Mode())}.merge(headers)
+	}
+	if u.sync {
+		headers = headers.merge(objectHeaders{Metadata: map[string]string{"mtime": mtimeMetadata(fileInfo.ModTime())}})
+	}
+
+	// a separate pass over the file, the body is streamed afterwards.
+	// Multipart uploads hash every part instead.
+	multipart := u.multipart(length, mimeType)
+	var contentMD5 *string
+	if u.contentMD5 && !multipart {
+		sum, err := fileMD5(path)
+		if err != nil {
+			return err
+		}
+		contentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum))
+	}
+
+	var sha256Sum string
+	if u.checksumMetadata || (u.contentSHA256 && !multipart) {
+		sha256Sum, err = fileSHA256(path)
+		if err != nil {
+			return err
+		}
+	}
+	if u.checksumMetadata {
+		headers = headers.merge(objectHeaders{Metadata: map[string]string{sha256MetadataKey: sha256Sum}})
+	}
+
+	options := u.putOptions
+	if u.contentSHA256 && !multipart {
+		options = append(options[:len(options):len(options)], withPayloadSHA256(sha256Sum))
+	}
+
+	// --generate-sri hashes the body while it is sent
+	var sri hash.Hash
+	if u.sri != nil {
+		sri = sha512.New384()
+	}
+
+	var etag string
+	if u.transferManager != nil && !u.singlePut(length, mimeType) {
+		etag, err = u.managerUpload(ctx, file, key, offset, length, mimeType, headers, sri, progress)
+	} else if multipart {
+		etag, err = u.uploadMultipart(ctx, file, key, offset, length, mimeType, headers, progress)
+		if err == nil && sri != nil {
+			// the parts are sent concurrently, hash the body in a pass of its own
+			_, err = io.Copy(sri, io.NewSectionReader(file, offset, length))
+		}
+	} else {
+		err = withRetry(ctx, func() error {
+			// rewind so a retry does not send a truncated body
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+
+			var body io.Reader = io.NewSectionReader(file, offset, length)
+			if u.readBufferSize > 0 {
+				body = bufio.NewReaderSize(body, u.readBufferSize)
+			}
+			if sri != nil {
+				sri.Reset()
+				body = io.TeeReader(body, sri)
+			}
+
+			input := &s3.PutObjectInput{
+				Bucket:        aws.String(bucketName),
+				Key:           aws.String(key),
+				RequestPayer:  requestPayer(),
+				Body:          NewProgressReader(u.limit(ctx, body), length, progress),
+				ContentType:   aws.String(mimeType),
+				ContentLength: length,
+				ContentMD5:    contentMD5,
+			}
+			headers.apply(input)
+
+			out, err := u.client.PutObject(ctx, input, options...)
+			if err != nil {
+				return err
+			}
+			etag = aws.ToString(out.ETag)
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	if u.dedup != nil {
+		u.dedup.add(etag, key)
+	}
+	if u.state != nil {
+		u.state.record(path, fileInfo, strings.Trim(etag, `"`))
+		u.checkpoint(false)
+	}
+	u.mu.Unlock()
+
+	if err := u.tagObject(ctx, key); err != nil {
+		return err
+	}
+
+	if u.compact != nil {
+		u.compact.done()
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if sri != nil {
+		integrity := "sha384-" + base64.StdEncoding.EncodeToString(sri.Sum(nil))
+		u.sri[path] = integrity
+		fmt.Printf("%s %s\n", key, integrity)
+	}
+	u.uploaded++
+	u.bytes += length
+	if u.parallel > 1 {
+		u.logf("Uploaded %s, %s in %s", key, formatBytes(length), time.Since(started).Round(time.Millisecond))
+	}
+	if u.events != nil {
+		u.events.emit(progressEvent{Event: "done", Path: path, Key: key, Bytes: length, Total: length})
+	}
+	return nil
+}
+
+// checkKey fails before any request is made when key is longer than
+// --max-key-length.
+func (u *uploader) checkKey(path, key string) error {
+	if u.maxKeyLength > 0 && len(key) > u.maxKeyLength {
+		return fmt.Errorf("the key of %s is %d bytes, longer than the limit of %d: %s", path, len(key), u.maxKeyLength, key)
+	}
+	return nil
+}
+
+// handleError aborts the run unless --ignore-errors or --continue-on-error
+// is set, in which case err is recorded and counted against
+// --max-error-count. key is empty when the file never got that far.
+func (u *uploader) handleError(path, key string, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.skipUnreadable && isUnreadable(err) {
+		log.Printf("Warning: skipping unreadable %s: %s", path, err)
+		u.unreadable = append(u.unreadable, path)
+		return
+	}
+
+	if u.events != nil {
+		u.events.emit(progressEvent{Event: "error", Path: path, Key: key, Error: err.Error()})
+	}
+
+	// a 403 would fail every other file the same way
+	if (!u.ignoreErrors && !u.continueOnError) || errors.Is(err, errAuth) {
+		u.checkpoint(true)
+		log.Fatalln(err)
+	}
+
+	u.failed++
+	u.failures = append(u.failures, uploadFailure{Path: path, Key: key, Error: err.Error()})
+	log.Printf("Failed to upload %s: %s", path, err)
+
+	if u.maxErrors > 0 && u.failed >= u.maxErrors {
+		u.checkpoint(true)
+		log.Fatalf("Aborting after %d errors (--max-error-count)", u.failed)
+	}
+}
+
+// isUnreadable reports whether err comes from opening or stat-ing a local
+// file, e.g. a permission error, rather than from talking to R2.
+func isUnreadable(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr) || errors.Is(err, fs.ErrPermission)
+}
+
+// printUnreadable lists the files skipped by --skip-if-source-unreadable.
+func (u *uploader) printUnreadable() {
+	log.Printf("Skipped %d unreadable files:", len(u.unreadable))
+	for _, path := range u.unreadable {
+		log.Printf("  %s", path)
+	}
+}
+
+// printFailures lists every failed file once the run is over.
+func (u *uploader) printFailures() {
+	log.Printf("%d files failed:", len(u.failures))
+	for _, f := range u.failures {
+		log.Printf("  %s: %s", f.Path, f.Error)
+	}
+}
+
+// writeErrorLog writes the failures as JSON so that they can be retried.
+func (u *uploader) writeErrorLog(path string) error {
+	failures := u.failures
+	if failures == nil {
+		failures = []uploadFailure{}
+	}
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// contentType picks the MIME type of name: --content-type if set, else from
+// its extension, looked up in the content_types: of the config file first.
+// Files without a known extension fall back to sniffing the first bytes
+// returned by head, which may be nil. --content-type-charset is applied to
+// text types.
+func (u *uploader) contentType(name string, head func() []byte) string {
+	if u.contentTypeOverride != "" {
+		return u.contentTypeOverride
+	}
+
+	ext := filepath.Ext(name)
+	mimeType := contentTypes[strings.ToLower(strings.TrimPrefix(ext, "."))]
+	if mimeType == "" && ext != "" {
+		mimeType = mime.TypeByExtension(ext)
+	}
+	if mimeType == "" && head != nil {
+		if data := head(); len(data) > 0 {
+			mimeType = http.DetectContentType(data)
+		}
+	}
+	if u.charset == "" || !strings.HasPrefix(mimeType, "text/") {
+		return mimeType
+	}
+
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeType
+	}
+	params["charset"] = u.charset
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// sniffLength is the most http.DetectContentType looks at.
+const sniffLength = 512
+
+// fileHead returns a function reading the first bytes of the file at path for
+// contentType, nil if it cannot be read.
+func fileHead(path string) func() []byte {
+	return func() []byte {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer file.Close()
+
+		data := make([]byte, sniffLength)
+		n, _ := io.ReadFull(file, data)
+		return data[:n]
+	}
+}
+
+// headersFor resolves the headers of one object: the plain flags first, then
+// the --cache-control rules in the order given, then the --metadata-map file.
+func (u *uploader) headersFor(path, key string) objectHeaders {
+	name := filepath.Base(path)
+	headers := u.headers
+	if acl := u.pathACLs.acl(key); acl != "" {
+		headers.ACL = acl
+	}
+	for _, rule := range u.cacheControl {
+		matchName, _ := filepath.Match(rule.pattern, name)
+		matchKey, _ := filepath.Match(rule.pattern, key)
+		if matchName || matchKey {
+			headers.CacheControl = rule.value
+		}
+	}
+	return u.rules.resolve(headers, name, key)
+}
+
+// printSummary renders the final summary with tmpl, elapsed is the duration
+// of the run.
+func (u *uploader) printSummary(tmpl *template.Template, elapsed time.Duration) error {
+	summary := uploadSummary{
+		Uploaded: u.uploaded,
+		Skipped:  u.skipped,
+		Empty:    u.skippedEmpty,
+		Failed:   u.failed,
+		Bytes:    u.bytes,
+		Duration: elapsed.Round(time.Millisecond),
+	}
+	if elapsed > 0 {
+		summary.Speed = float64(u.bytes) / elapsed.Seconds()
+	}
+
+	if u.events != nil {
+		u.events.emit(progressEvent{
+			Event:    "summary",
+			Uploaded: summary.Uploaded,
+			Skipped:  summary.Skipped + summary.Empty,
+			Failed:   summary.Failed,
+			Bytes:    summary.Bytes,
+			Seconds:  elapsed.Seconds(),
+		})
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, summary); err != nil {
+		return err
+	}
+	log.Print(b.String())
+	return nil
+}
+
+// logf prints a per-file message unless per-file output is suppressed by
+// --quiet or replaced by the progress bar or the events of --progress json.
+func (u *uploader) logf(format string, v ...any) {
+	if u.quiet || u.compact != nil || u.events != nil {
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// planFile records the decision made for the file at path and key during a
+// dry run. Uploads replacing an object are planned as overwrites, for --force
+// the object is looked up to tell them apart.
+func (u *uploader) planFile(ctx context.Context, path, key string, skip bool, reason string, size int64) error {
+	if reason == "force" {
+		exists, err := u.exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			reason = "new"
+		}
+	}
+
+	rel := path
+	if r, err := filepath.Rel(u.root, path); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	entry := plannedUpload{Path: rel, Key: key, Action: "upload", Reason: reason, Size: size, ContentType: u.contentType(path, fileHead(path))}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch {
+	case skip:
+		entry.Action = "skip"
+		u.skipped++
+	case reason != "new":
+		entry.Action = "overwrite"
+		fallthrough
+	default:
+		u.uploaded++
+		u.bytes += size
+	}
+	u.plan = append(u.plan, entry)
+	return nil
+}
+
+// printPlan writes the dry-run report to stdout as text or JSON.
+func (u *uploader) printPlan(output string) error {
+	// parallel uploads plan in the order they finish
+	sort.SliceStable(u.plan, func(i, j int) bool { return u.plan[i].Key < u.plan[j].Key })
+
+	if output == "json" {
+		plan := u.plan
+		if plan == nil {
+			plan = []plannedUpload{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+
+	overwrites := 0
+	for _, entry := range u.plan {
+		if entry.Action == "overwrite" {
+			overwrites++
+		}
+		fmt.Printf("%-9s %-10s %12d %-24s %s -> %s\n", entry.Action, entry.Reason, entry.Size, entry.ContentType, entry.Path, entry.Key)
+	}
+	fmt.Printf("Would upload %d files (%d bytes, %d overwriting existing objects), skip %d files\n", u.uploaded, u.bytes, overwrites, u.skipped)
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// headClient returns a client whose requests fail with err before they are
+// sent, or succeed with an empty output when err is nil.
+func headClient(err error) *s3.Client {
+	return s3.New(s3.Options{
+		Region:  "auto",
+		Retryer: aws.NopRetryer{},
+		APIOptions: []func(*middleware.Stack) error{
+			func(stack *middleware.Stack) error {
+				return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fakeHead",
+					func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+						return middleware.InitializeOutput{Result: &s3.HeadObjectOutput{}}, middleware.Metadata{}, err
+					}), middleware.Before)
+			},
+		},
+	})
+}
+
+// statusError is a bare HTTP error response without an API error code.
+func statusError(code int) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: code, Header: http.Header{}}},
+		Err:      errors.New(http.StatusText(code)),
+	}
+}
+
+func TestDecideHeadObjectErrors(t *testing.T) {
+	defer func(retries int, failFast, skipDenied bool) {
+		maxRetries, failFastOnAuthError, skipOnAccessDenied = retries, failFast, skipDenied
+	}(maxRetries, failFastOnAuthError, skipOnAccessDenied)
+	maxRetries = 0
+	failFastOnAuthError = false
+
+	tests := []struct {
+		name       string
+		headErr    error
+		skipDenied bool
+		wantSkip   bool
+		wantReason string
+		wantStatus int // status of the error expected back, 0 for none
+	}{
+		{name: "found", wantSkip: true, wantReason: "exists"},
+		{name: "NotFound", headErr: &types.NotFound{}, wantReason: "new"},
+		{name: "NoSuchKey code", headErr: &smithy.GenericAPIError{Code: "NoSuchKey"}, wantReason: "new"},
+		{name: "bare 404", headErr: statusError(404), wantReason: "new"},
+		{name: "403", headErr: statusError(403), wantStatus: 403},
+		{name: "403 skip on access denied", headErr: statusError(403), skipDenied: true, wantSkip: true, wantReason: "exists"},
+		{name: "500", headErr: statusError(500), wantStatus: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skipOnAccessDenied = tt.skipDenied
+			u := &uploader{client: headClient(tt.headErr)}
+
+			skip, reason, err := u.decide(context.Background(), "file.txt", "dir/file.txt")
+			if tt.wantStatus != 0 {
+				var respErr *smithyhttp.ResponseError
+				if !errors.As(err, &respErr) || respErr.HTTPStatusCode() != tt.wantStatus {
+					t.Fatalf("decide() error = %v, want the %d response", err, tt.wantStatus)
+				}
+				if skip {
+					t.Errorf("decide() skip = true with an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decide() error = %v", err)
+			}
+			if skip != tt.wantSkip || reason != tt.wantReason {
+				t.Errorf("decide() = %v, %q, want %v, %q", skip, reason, tt.wantSkip, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestPlanFileForceReason(t *testing.T) {
+	defer func(retries int) { maxRetries = retries }(maxRetries)
+	maxRetries = 0
+
+	tests := []struct {
+		name       string
+		headErr    error
+		wantAction string
+		wantReason string
+		wantErr    bool
+	}{
+		{name: "found", wantAction: "overwrite", wantReason: "force"},
+		{name: "NotFound", headErr: &types.NotFound{}, wantAction: "upload", wantReason: "new"},
+		{name: "500", headErr: statusError(500), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &uploader{client: headClient(tt.headErr), force: true}
+
+			err := u.planFile(context.Background(), "file.txt", "dir/file.txt", false, "force", 1)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("planFile() error = nil, want the HeadObject error")
+				}
+				if len(u.plan) != 0 {
+					t.Errorf("planFile() planned %v after an error", u.plan)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planFile() error = %v", err)
+			}
+			if got := u.plan[0]; got.Action != tt.wantAction || got.Reason != tt.wantReason {
+				t.Errorf("planFile() = %s %q, want %s %q", got.Action, got.Reason, tt.wantAction, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestUploadFileNoClobber(t *testing.T) {
+	defer func(retries int) { maxRetries = retries }(maxRetries)
+	maxRetries = 0
+
+	tests := []struct {
+		name        string
+		headErr     error
+		wantClobber bool
+	}{
+		{name: "found", wantClobber: true},
+		{name: "500", headErr: statusError(500)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &uploader{client: headClient(tt.headErr), noClobber: true}
+
+			err := u.uploadFile(context.Background(), "file.txt", "dir/file.txt")
+			if err == nil {
+				t.Fatal("uploadFile() error = nil")
+			}
+			if errors.Is(err, errClobber) != tt.wantClobber {
+				t.Errorf("uploadFile() error = %v, errClobber %v", err, tt.wantClobber)
+			}
+		})
+	}
+}
+
+func TestParseKeyRewrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "none", key: "a/b.txt", want: "a/b.txt"},
+		{name: "references", values: []string{`^build/(.*)\.htm$=site/$1.html`}, key: "build/index.htm", want: "site/index.html"},
+		{name: "no match", values: []string{`^build/=site/`}, key: "src/index.htm", want: "src/index.htm"},
+		{name: "in order", values: []string{`^a/=b/`, `^b/=c/`}, key: "a/x", want: "c/x"},
+		{name: "equals in the replacement", values: []string{`\.txt$=.txt?v=1`}, key: "a.txt", want: "a.txt?v=1"},
+		{name: "empty replacement", values: []string{`^tmp/=`}, key: "tmp/x", want: "x"},
+		{name: "no equals", values: []string{`^build/`}, wantErr: true},
+		{name: "empty pattern", values: []string{`=site/`}, wantErr: true},
+		{name: "invalid pattern", values: []string{`(build=site`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewrites, err := parseKeyRewrites(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKeyRewrites() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			u := &uploader{keyRewrites: rewrites}
+			if got := u.rewriteKey(tt.key); got != tt.want {
+				t.Errorf("rewriteKey(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cuipeiyu/cloudflare-r2-uploader/r2"
+	"github.com/spf13/cobra"
+)
+
+// verifyFile compares the file at path with the object key and returns a
+// problem, or "" if they match. The SHA-256 stored by --checksum-metadata is
+// checked when the object has one, its ETag otherwise. ok is false when
+// neither can be compared, e.g. for a multipart ETag of another part size.
+func (u *uploader) verifyFile(ctx context.Context, path, key string) (problem string, ok bool, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	var head *s3.HeadObjectOutput
+	err = withRetry(ctx, func() (err error) {
+		head, err = u.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucketName),
+			Key:          aws.String(key),
+			RequestPayer: requestPayer(),
+		})
+		return err
+	})
+	if isNotFound(err) {
+		return "missing", true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	if head.ContentLength != info.Size() {
+		return fmt.Sprintf("size %d, the object has %d", info.Size(), head.ContentLength), true, nil
+	}
+
+	if remote := head.Metadata[sha256MetadataKey]; remote != "" {
+		local, err := fileSHA256(path)
+		if err != nil {
+			return "", false, err
+		}
+		if !strings.EqualFold(local, remote) {
+			return fmt.Sprintf("SHA-256 %s, the object has %s", local, remote), true, nil
+		}
+		return "", true, nil
+	}
+
+	remote := strings.Trim(aws.ToString(head.ETag), `"`)
+	local, err := u.localETag(path, info.Size(), remote)
+	if err != nil {
+		return "", false, err
+	}
+	if local == "" {
+		return "", false, nil
+	}
+	if local != remote {
+		return fmt.Sprintf("ETag %s, the object has %s", local, remote), true, nil
+	}
+	return "", true, nil
+}
+
+func verifyCmd() *cobra.Command {
+	verify := &cobra.Command{
+		Use:   "verify <local-path> <remote-path>",
+		Short: "check the objects against the local files they were uploaded from",
+		Long:  "",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			partSize, _ := cmd.Flags().GetInt64("part-size")
+			followSymlinks, _ := cmd.Flags().GetBool("follow-symlinks")
+
+			if parallel < 1 {
+				log.Fatalln("--parallel must be at least 1")
+			}
+			if partSize < r2.MinPartSize {
+				log.Fatalf("--part-size must be at least %d bytes", r2.MinPartSize)
+			}
+
+			localPath := args[0]
+			remotePath := strings.TrimLeft(args[1], "/")
+
+			info, err := os.Stat(localPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			u := &uploader{client: client, partSize: partSize}
+
+			var (
+				mu                       sync.Mutex
+				matched, unverified, bad int
+			)
+			verifyOne := func(path, key string) {
+				problem, ok, err := u.verifyFile(ctx, path, key)
+				if ctx.Err() != nil {
+					return
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err != nil:
+					bad++
+					log.Printf("Failed to verify %s: %s", key, err)
+				case problem != "":
+					bad++
+					log.Printf("Mismatch %s: %s", key, problem)
+				case !ok:
+					unverified++
+					log.Printf("Cannot verify %s, it was uploaded in parts of another size than --part-size", key)
+				default:
+					matched++
+					if !quiet {
+						log.Printf("OK %s", key)
+					}
+				}
+			}
+
+			if info.IsDir() {
+				root, _ := filepath.Abs(localPath)
+				pool := newUploadPool(parallel, verifyOne)
+				walkFiles(root, followSymlinks, u.skipSpecial, func(path string, info fs.FileInfo, err error) error {
+					if ctx.Err() != nil {
+						return ctx.Err() // stop walking
+					}
+					if err != nil {
+						mu.Lock()
+						bad++
+						mu.Unlock()
+						log.Printf("Failed to verify %s: %s", path, err)
+						return nil
+					}
+					if info.IsDir() {
+						return nil
+					}
+					if !info.Mode().IsRegular() {
+						u.skipSpecial(path, "it is not a regular file ("+info.Mode().String()+")")
+						return nil
+					}
+
+					key := strings.TrimPrefix(path, root)
+					key = strings.TrimPrefix(filepath.Join(remotePath, key), "/")
+					pool.add(path, key)
+					return nil
+				})
+				pool.wait()
+			} else {
+				verifyOne(localPath, remotePath)
+			}
+
+			if ctx.Err() != nil {
+				log.Fatalln("Verify interrupted")
+			}
+
+			log.Printf("Verified %d files, %d match, %d do not, %d could not be compared", matched+bad+unverified, matched, bad, unverified)
+			if len(u.skippedSpecial) > 0 {
+				u.printSkippedSpecial()
+			}
+			if bad > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+
+	verify.Flags().BoolP("quiet", "q", false, "Only print the files that do not match and the summary.")
+	verify.Flags().Int("parallel", 4, "Number of files checked at the same time.")
+	verify.Flags().Int64("part-size", 16<<20, "Part size the files were uploaded with, to compute the ETags of multipart uploads.")
+	verify.Flags().Bool("follow-symlinks", false, "Check the targets of symlinks and walk symlinked directories like upload --follow-symlinks, instead of skipping symlinks with a warning.")
+
+	return verify
+}
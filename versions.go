@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// objectVersion is one entry of list --list-all-versions, either a version of
+// an object or a delete marker.
+type objectVersion struct {
+	Key          string    `json:"key"`
+	VersionID    string    `json:"versionId"`
+	IsLatest     bool      `json:"isLatest"`
+	DeleteMarker bool      `json:"deleteMarker"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	StorageClass string    `json:"storageClass,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// listVersions returns every version and delete marker below prefix, ordered
+// by key and then from the newest to the oldest. ListObjectVersions has no
+// paginator, the key and version markers are followed by hand.
+func listVersions(ctx context.Context, client *s3.Client, prefix string, maxPages int) ([]objectVersion, error) {
+	var versions []objectVersion
+
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	}
+	for pages := 0; ; pages++ {
+		if maxPages > 0 && pages >= maxPages {
+			log.Printf("Stopped after %d pages (--max-list-pages), the listing is incomplete", pages)
+			break
+		}
+
+		var page *s3.ListObjectVersionsOutput
+		err := withRetry(ctx, func() (err error) {
+			page, err = client.ListObjectVersions(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range page.Versions {
+			versions = append(versions, objectVersion{
+				Key:          aws.ToString(v.Key),
+				VersionID:    aws.ToString(v.VersionId),
+				IsLatest:     v.IsLatest,
+				Size:         v.Size,
+				ETag:         aws.ToString(v.ETag),
+				StorageClass: string(v.StorageClass),
+				LastModified: aws.ToTime(v.LastModified),
+			})
+		}
+		for _, m := range page.DeleteMarkers {
+			versions = append(versions, objectVersion{
+				Key:          aws.ToString(m.Key),
+				VersionID:    aws.ToString(m.VersionId),
+				IsLatest:     m.IsLatest,
+				DeleteMarker: true,
+				LastModified: aws.ToTime(m.LastModified),
+			})
+		}
+
+		if !page.IsTruncated {
+			break
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.VersionIdMarker = page.NextVersionIdMarker
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Key != versions[j].Key {
+			return versions[i].Key < versions[j].Key
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// errSymlinkCycle is passed to the walk function for a symlink leading back
+// to a directory that is being walked.
+var errSymlinkCycle = errors.New("symlink cycle")
+
+// walkTree walks the tree at root like filepath.Walk, in lexical order. By
+// default symlinks are reported as such, with follow they are reported with
+// the info of their target and symlinked directories are walked as if they
+// were below root. A link back to one of the directories being walked is
+// reported with errSymlinkCycle instead of being entered.
+func walkTree(root string, follow bool, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err == nil {
+		// the root itself is always followed, like the path of a single file
+		info, err = os.Stat(root)
+	}
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkPath(root, info, follow, map[string]bool{}, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkPath(path string, info fs.FileInfo, follow bool, ancestors map[string]bool, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	real, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		if ancestors[real] {
+			return fn(path, info, errSymlinkCycle)
+		}
+		ancestors[real] = true
+		defer delete(ancestors, real)
+	}
+
+	entries, err := os.ReadDir(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		childInfo, err := os.Lstat(child)
+		if err == nil && follow && childInfo.Mode()&fs.ModeSymlink != 0 {
+			// a dangling link is reported with its own info and the error
+			var target fs.FileInfo
+			if target, err = os.Stat(child); err == nil {
+				childInfo = target
+			}
+		}
+		if err != nil {
+			if err := fn(child, childInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		if err := walkPath(child, childInfo, follow, ancestors, fn); err != nil {
+			if !childInfo.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkFiles walks root with walkTree and calls fn like filepath.Walk, except
+// for the symlinks it does not follow, links back to a directory being
+// walked and links whose target cannot be read. Those are passed to skip
+// with the reason instead.
+func walkFiles(root string, follow bool, skip func(path, why string), fn filepath.WalkFunc) error {
+	return walkTree(root, follow, func(path string, info fs.FileInfo, err error) error {
+		switch {
+		case errors.Is(err, errSymlinkCycle):
+			skip(path, "it links back to a directory being walked")
+			return nil
+		case info != nil && info.Mode()&fs.ModeSymlink != 0 && err != nil:
+			skip(path, "its target cannot be read: "+err.Error())
+			return nil
+		case info != nil && info.Mode()&fs.ModeSymlink != 0:
+			skip(path, "it is a symlink, use --follow-symlinks to follow it")
+			return nil
+		}
+		return fn(path, info, err)
+	})
+}
+
+// skipSpecial reports a symlink, a symlink cycle or a special file left out
+// of the upload, listed again by printSkippedSpecial.
+func (u *uploader) skipSpecial(path, why string) {
+	log.Printf("Warning: skipping %s, %s", path, why)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.skippedSpecial = append(u.skippedSpecial, path)
+}
+
+// printSkippedSpecial lists the symlinks and special files the upload left
+// out.
+func (u *uploader) printSkippedSpecial() {
+	log.Printf("Skipped %d symlinks and special files:", len(u.skippedSpecial))
+	for _, path := range u.skippedSpecial {
+		log.Printf("  %s", path)
+	}
+}
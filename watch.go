@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+// watcher mirrors a local directory to a remote path as it changes.
+type watcher struct {
+	u          *uploader
+	fs         *fsnotify.Watcher
+	remotePath string
+	// deleteRemoved deletes the objects of removed files
+	deleteRemoved bool
+	debounce      time.Duration
+	// followSymlinks uploads the targets of symlinks and watches symlinked
+	// directories
+	followSymlinks bool
+	// patterns of --watch-patterns, only the files matching one of them are
+	// uploaded or deleted
+	patterns []string
+
+	// pending holds the paths that changed and when they last did, a path
+	// is handled once it has been quiet for debounce
+	pending map[string]time.Time
+}
+
+// key returns the key of the local path below u.root, like sync does.
+func (w *watcher) key(path string) string {
+	key := strings.TrimPrefix(path, w.u.root)
+	return strings.TrimPrefix(filepath.Join(w.remotePath, key), "/")
+}
+
+// watched reports whether the file at path matches --watch-patterns, see
+// matchPattern.
+func (w *watcher) watched(path string) bool {
+	if len(w.patterns) == 0 {
+		return true
+	}
+	rel, err := filepath.Rel(w.u.root, path)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range w.patterns {
+		if matchPattern(pattern, filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip warns about a symlink left alone. Unlike upload there is no summary
+// at the end to list it in.
+func (w *watcher) skip(path, why string) {
+	log.Printf("Warning: skipping %s, %s", path, why)
+}
+
+// addTree watches dir and the directories below it. With queue the files
+// found are queued as well, for a directory created while watching.
+func (w *watcher) addTree(dir string, queue bool) error {
+	return walkFiles(dir, w.followSymlinks, w.skip, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Warning: not watching %s: %s", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			return w.fs.Add(path)
+		}
+		if queue && w.watched(path) {
+			w.pending[path] = time.Now()
+		}
+		return nil
+	})
+}
+
+// run handles the events until ctx is done.
+func (w *watcher) run(ctx context.Context) error {
+	ticker := time.NewTicker(w.debounce / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				stat := os.Lstat
+				if w.followSymlinks {
+					stat = os.Stat
+				}
+				if info, err := stat(event.Name); err == nil && info.IsDir() {
+					if err := w.addTree(event.Name, true); err != nil {
+						log.Printf("Warning: not watching %s: %s", event.Name, err)
+					}
+					continue
+				}
+			}
+			w.pending[event.Name] = time.Now()
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Warning: %s", err)
+		case now := <-ticker.C:
+			for path, changed := range w.pending {
+				if now.Sub(changed) < w.debounce {
+					continue
+				}
+				delete(w.pending, path)
+				w.handle(ctx, path)
+			}
+		}
+	}
+}
+
+// handle uploads the file at path if it changed, or deletes its object once
+// it is gone. Failures are reported and the watch goes on.
+func (w *watcher) handle(ctx context.Context, path string) {
+	key := w.key(path)
+
+	stat := os.Lstat
+	if w.followSymlinks {
+		stat = os.Stat
+	}
+	info, err := stat(path)
+	switch {
+	case err == nil && info.Mode()&fs.ModeSymlink != 0:
+		w.skip(path, "it is a symlink, use --follow-symlinks to follow it")
+	case err == nil && info.Mode().IsRegular():
+		if !w.watched(path) {
+			debugf("Ignoring %s, it does not match --watch-patterns", path)
+			return
+		}
+		if err := w.u.uploadFile(ctx, path, key); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to upload %s: %s", path, err)
+		}
+		w.printPlan()
+	case errors.Is(err, fs.ErrNotExist) && w.deleteRemoved:
+		// the path may have been a directory, its objects go as well
+		below, err := listKeys(ctx, w.u.client, key+"/")
+		if err != nil {
+			log.Printf("Failed to delete %s: %s", key, err)
+			return
+		}
+		var keys []string
+		for _, k := range append(below, key) {
+			rel := strings.TrimPrefix(strings.TrimPrefix(k, key), "/")
+			if w.watched(filepath.Join(path, filepath.FromSlash(rel))) {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			return
+		}
+		if w.u.dryRun {
+			for _, key := range keys {
+				log.Printf("Would delete %s", key)
+			}
+			return
+		}
+		if !confirmDelete(keys) {
+			log.Printf("Keeping %s", key)
+			return
+		}
+		if err := deleteObjects(ctx, w.u.client, keys, maxDeleteObjects); err != nil {
+			log.Printf("Failed to delete %s: %s", key, err)
+			return
+		}
+		w.u.logf("Deleted %s", key)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		log.Printf("Failed to upload %s: %s", path, err)
+	}
+}
+
+// printPlan reports what --dry-run would have done for the files handled
+// since the last call.
+func (w *watcher) printPlan() {
+	for _, entry := range w.u.plan {
+		log.Printf("Would %s %s to %s (%s)", entry.Action, entry.Path, entry.Key, entry.Reason)
+	}
+	w.u.plan = nil
+}
+
+func watchCmd() *cobra.Command {
+	watch := &cobra.Command{
+		Use:         "watch <local-dir> <remote-path>",
+		Annotations: map[string]string{dryRunAnnotation: "true"},
+		Short:       "upload the files of a directory as they change",
+		Long:        "",
+		Args:        cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			deleteRemoved, _ := cmd.Flags().GetBool("delete")
+			debounce, _ := cmd.Flags().GetDuration("debounce")
+			initialSync, _ := cmd.Flags().GetBool("initial-sync")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			followSymlinks, _ := cmd.Flags().GetBool("follow-symlinks")
+			patterns, _ := cmd.Flags().GetStringSlice("watch-patterns")
+
+			if debounce <= 0 {
+				log.Fatalln("--debounce must be positive")
+			}
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					log.Fatalf("invalid --watch-patterns pattern %q: %s", pattern, err)
+				}
+			}
+
+			info, err := os.Stat(args[0])
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if !info.IsDir() {
+				log.Fatalln("watch needs a local directory")
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			client, err := newR2Client(ctx)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			fsWatcher, err := fsnotify.NewWatcher()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer fsWatcher.Close()
+
+			u := &uploader{
+				client:             client,
+				parallel:           1,
+				force:              true,
+				sync:               true,
+				quiet:              quiet,
+				dryRun:             dryRun,
+				readBufferSize:     256 << 10,
+				multipartThreshold: 100 << 20,
+				partSize:           16 << 20,
+				partConcurrency:    4,
+				skipUnsupported:    true,
+			}
+			u.root, _ = filepath.Abs(args[0])
+
+			w := &watcher{
+				u:              u,
+				fs:             fsWatcher,
+				remotePath:     strings.TrimLeft(args[1], "/"),
+				deleteRemoved:  deleteRemoved,
+				debounce:       debounce,
+				followSymlinks: followSymlinks,
+				patterns:       patterns,
+				pending:        map[string]time.Time{},
+			}
+
+			// watch before the initial pass, so no change is missed
+			if err := w.addTree(u.root, initialSync); err != nil {
+				log.Fatalln(err)
+			}
+
+			log.Printf("Watching %s for changes to upload to %s", u.root, w.remotePath)
+			if err := w.run(ctx); err != nil {
+				log.Fatalln(err)
+			}
+
+			// Ctrl-C is the normal way to stop watching
+			if pending := openMultipartUploads(); len(pending) > 0 {
+				log.Printf("Cleaning up %d incomplete uploads...", len(pending))
+				abortMultipartUploads(client, pending)
+			}
+			log.Println("Stopped watching")
+		},
+	}
+
+	watch.Flags().Bool("delete", false, "Delete the objects of removed files, and every object below a removed directory. Asks first like delete unless --yes is given or stdin is not a terminal.")
+	watch.Flags().Duration("debounce", 500*time.Millisecond, "Wait until a file has not changed for this long before uploading it.")
+	watch.Flags().Bool("initial-sync", true, "Upload the files that differ from their objects when the watch starts.")
+	watch.Flags().BoolP("quiet", "q", false, "Only print failures.")
+	watch.Flags().StringSlice("watch-patterns", nil, "Comma separated patterns, matched like the --exclude patterns of upload, e.g. '*.html,*.css,*.js'. Only the files matching one of them are uploaded, or deleted with --delete.")
+	watch.Flags().Bool("follow-symlinks", false, "Upload the targets of symlinks and watch symlinked directories, instead of skipping symlinks with a warning.")
+
+	return watch
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWatcherWatched(t *testing.T) {
+	root := filepath.FromSlash("/site")
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		want     bool
+	}{
+		{name: "no patterns", path: "dist/app.js.tmp", want: true},
+		{name: "extension", patterns: []string{"*.html", "*.js"}, path: "dist/app.js", want: true},
+		{name: "temporary file", patterns: []string{"*.html", "*.js"}, path: "dist/app.js.tmp", want: false},
+		{name: "path pattern", patterns: []string{"assets/**/*.css"}, path: "assets/css/main.css", want: true},
+		{name: "path pattern elsewhere", patterns: []string{"assets/**/*.css"}, path: "other/main.css", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &watcher{u: &uploader{root: root}, patterns: tt.patterns}
+			if got := w.watched(filepath.Join(root, filepath.FromSlash(tt.path))); got != tt.want {
+				t.Errorf("watched(%s) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
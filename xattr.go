@@ -0,0 +1,66 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrSupported tells whether --upload-metadata-from-xattr works here.
+const xattrSupported = true
+
+// xattrMetadata returns the user.* extended attributes of the file at path as
+// object metadata, named without the user. prefix.
+func xattrMetadata(path string) (map[string]string, error) {
+	names, err := xattrGet(func(dest []byte) (int, error) {
+		return unix.Listxattr(path, dest)
+	})
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil // the file system has no extended attributes
+		}
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	for _, name := range bytes.Split(names, []byte{0}) {
+		attr := string(name)
+		if !strings.HasPrefix(attr, "user.") {
+			continue
+		}
+
+		value, err := xattrGet(func(dest []byte) (int, error) {
+			return unix.Getxattr(path, attr, dest)
+		})
+		if err != nil {
+			return nil, err
+		}
+		metadata[strings.TrimPrefix(attr, "user.")] = string(value)
+	}
+	return metadata, nil
+}
+
+// xattrGet calls get first without a buffer to learn the size of the result,
+// then with one, and again when the attributes grew in between.
+func xattrGet(get func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := get(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		buf := make([]byte, size)
+		n, err := get(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
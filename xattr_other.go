@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// xattrSupported tells whether --upload-metadata-from-xattr works here.
+const xattrSupported = false
+
+func xattrMetadata(path string) (map[string]string, error) {
+	return nil, errors.New("extended attributes are not supported on this platform")
+}